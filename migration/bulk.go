@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxInFlightWrites bounds how many writes are queued on the BulkWriter before waiting for their results
+	maxInFlightWrites = 500
+	// progressInterval is how many documents are written between progress logs
+	progressInterval = 1000
+	// maxWriteAttempts is how many passes are made over writes that keep failing. The BulkWriter already retries
+	// retryable codes on its own; this covers writes it gave up on.
+	maxWriteAttempts = 3
+)
+
+// docWrite is a single document to be written to, or deleted from, a collection.
+type docWrite struct {
+	ID     string
+	Data   any
+	Delete bool
+}
+
+type failedWrite struct {
+	write docWrite
+	err   error
+}
+
+// bulkWrite applies every write to the collection using a BulkWriter. Writes that fail are retried in a fresh
+// BulkWriter up to maxWriteAttempts times before giving up.
+func bulkWrite(ctx context.Context, db *firestore.Client, collectionName string, writes []docWrite) error {
+	l := log.With().Str("collection", collectionName).Int("total", len(writes)).Logger()
+	written := 0
+	pending := writes
+	for attempt := 1; ; attempt++ {
+		failed := runBulkWriter(ctx, db, collectionName, pending, &written)
+		if len(failed) == 0 {
+			return nil
+		}
+		if attempt >= maxWriteAttempts {
+			return fmt.Errorf(
+				"failed to write %d documents to %s after %d attempts: %w",
+				len(failed), collectionName, attempt, failed[0].err,
+			)
+		}
+		l.Warn().
+			Err(failed[0].err).
+			Int("failed", len(failed)).
+			Int("attempt", attempt).
+			Msg("retrying failed writes")
+
+		pending = make([]docWrite, 0, len(failed))
+		for _, f := range failed {
+			pending = append(pending, f.write)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+type queuedWrite struct {
+	write docWrite
+	job   *firestore.BulkWriterJob
+}
+
+// runBulkWriter queues the writes in batches of maxInFlightWrites and returns the ones that failed.
+func runBulkWriter(ctx context.Context, db *firestore.Client, collectionName string, writes []docWrite, written *int) []failedWrite {
+	bw := db.BulkWriter(ctx)
+	defer bw.End()
+
+	var failed []failedWrite
+	queued := make([]queuedWrite, 0, maxInFlightWrites)
+	collect := func() {
+		bw.Flush()
+		for _, q := range queued {
+			if _, err := q.job.Results(); err != nil {
+				log.Error().Str("docID", q.write.ID).Err(err).Msg("failed to save definition")
+				failed = append(failed, failedWrite{write: q.write, err: err})
+				continue
+			}
+			*written++
+			if *written%progressInterval == 0 {
+				log.Info().Str("collection", collectionName).Int("written", *written).Msg("migration progress")
+			}
+		}
+		queued = queued[:0]
+	}
+
+	for _, w := range writes {
+		ref := db.Collection(collectionName).Doc(w.ID)
+		var job *firestore.BulkWriterJob
+		var err error
+		if w.Delete {
+			job, err = bw.Delete(ref)
+		} else {
+			job, err = bw.Set(ref, w.Data)
+		}
+		if err != nil {
+			failed = append(failed, failedWrite{write: w, err: err})
+			continue
+		}
+		queued = append(queued, queuedWrite{write: w, job: job})
+		if len(queued) >= maxInFlightWrites {
+			collect()
+		}
+	}
+	collect()
+	return failed
+}
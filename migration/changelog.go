@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+const (
+	ManifestChangesCollection = "d2ManifestChanges"
+	changeEntriesCollection   = "entries"
+	// getAllChunkSize keeps GetAll requests to a reasonable size for the largest collections
+	getAllChunkSize = 300
+)
+
+type ChangeType string
+
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeChanged ChangeType = "changed"
+	ChangeRemoved ChangeType = "removed"
+)
+
+// ManifestChange records how a single definition changed between two manifest versions.
+type ManifestChange struct {
+	Collection      string      `firestore:"collection" json:"collection"`
+	DefinitionID    string      `firestore:"definitionId" json:"definitionId"`
+	Name            string      `firestore:"name" json:"name"`
+	Change          ChangeType  `firestore:"change" json:"change"`
+	Fields          []FieldDiff `firestore:"fields" json:"fields"`
+	Version         string      `firestore:"version" json:"version"`
+	PreviousVersion string      `firestore:"previousVersion" json:"previousVersion"`
+}
+
+// FieldDiff is the before and after value of a key property of a definition.
+type FieldDiff struct {
+	Field  string `firestore:"field" json:"field"`
+	Before any    `firestore:"before" json:"before"`
+	After  any    `firestore:"after" json:"after"`
+}
+
+// keyFields are the properties of a definition the changelog tracks.
+type keyFields struct {
+	Name            string
+	InvestmentStats map[string]int
+	Sockets         []socketKey
+}
+
+// socketKey is the part of a socket entry that decides which plugs can roll in it.
+type socketKey struct {
+	SocketTypeHash        int64 `firestore:"socketTypeHash" json:"socketTypeHash"`
+	SingleInitialItemHash int64 `firestore:"singleInitialItemHash" json:"singleInitialItemHash"`
+	ReusablePlugSetHash   int64 `firestore:"reusablePlugSetHash" json:"reusablePlugSetHash"`
+	RandomizedPlugSetHash int64 `firestore:"randomizedPlugSetHash" json:"randomizedPlugSetHash"`
+}
+
+func extractKeyFields(item any) keyFields {
+	fields := keyFields{}
+	v := reflect.Indirect(reflect.ValueOf(item))
+	if v.Kind() == reflect.Struct {
+		if dp := v.FieldByName("DisplayProperties"); dp.IsValid() && dp.Kind() == reflect.Struct {
+			if name := dp.FieldByName("Name"); name.IsValid() && name.Kind() == reflect.String {
+				fields.Name = name.String()
+			}
+		}
+	}
+	if definition, ok := item.(ItemDefinition); ok {
+		fields.InvestmentStats = make(map[string]int, len(definition.InvestmentStats))
+		for _, stat := range definition.InvestmentStats {
+			fields.InvestmentStats[strconv.FormatInt(stat.StatTypeHash, 10)] = stat.Value
+		}
+		if definition.Sockets != nil {
+			for _, entry := range definition.Sockets.SocketEntries {
+				fields.Sockets = append(fields.Sockets, socketKey{
+					SocketTypeHash:        entry.SocketTypeHash,
+					SingleInitialItemHash: entry.SingleInitialItemHash,
+					ReusablePlugSetHash:   entry.ReusablePlugSetHash,
+					RandomizedPlugSetHash: entry.RandomizedPlugSetHash,
+				})
+			}
+		}
+	}
+	return fields
+}
+
+func diffKeyFields(before, after keyFields) []FieldDiff {
+	diffs := make([]FieldDiff, 0)
+	if before.Name != after.Name {
+		diffs = append(diffs, FieldDiff{Field: "name", Before: before.Name, After: after.Name})
+	}
+	if !reflect.DeepEqual(before.InvestmentStats, after.InvestmentStats) {
+		diffs = append(diffs, FieldDiff{Field: "investmentStats", Before: before.InvestmentStats, After: after.InvestmentStats})
+	}
+	if !slices.Equal(before.Sockets, after.Sockets) {
+		diffs = append(diffs, FieldDiff{Field: "sockets", Before: before.Sockets, After: after.Sockets})
+	}
+	return diffs
+}
+
+// loadPrevious reads the stored definitions for the given IDs before they are overwritten, decoding them into the
+// same type as the new definitions.
+func loadPrevious(ctx context.Context, db *firestore.Client, collectionName string, IDs []string, itemType reflect.Type) (map[string]any, error) {
+	previous := make(map[string]any, len(IDs))
+	for i := 0; i < len(IDs); i += getAllChunkSize {
+		end := min(i+getAllChunkSize, len(IDs))
+		refs := make([]*firestore.DocumentRef, 0, end-i)
+		for _, ID := range IDs[i:end] {
+			refs = append(refs, db.Collection(collectionName).Doc(ID))
+		}
+		docs, err := db.GetAll(ctx, refs)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+			item := reflect.New(itemType)
+			if err := doc.DataTo(item.Interface()); err != nil {
+				return nil, fmt.Errorf("failed to read previous %s: %w", doc.Ref.ID, err)
+			}
+			previous[doc.Ref.ID] = item.Elem().Interface()
+		}
+	}
+	return previous, nil
+}
+
+// buildChangelog lists the added and removed definitions, and the changed ones whose key fields differ. Entries are
+// labelled with the logical collection name, while the previous definitions are read from the slot being served.
+func buildChangelog(
+	ctx context.Context,
+	db *firestore.Client,
+	collectionName, activeCollection string,
+	writes []docWrite,
+	changes []docWrite,
+	previousHashes map[string]string,
+	previousVersion, version string,
+) ([]ManifestChange, error) {
+	if len(writes) == 0 {
+		return nil, nil
+	}
+	IDs := make([]string, 0)
+	for _, w := range changes {
+		if _, ok := previousHashes[w.ID]; ok {
+			IDs = append(IDs, w.ID)
+		}
+	}
+	previous, err := loadPrevious(ctx, db, activeCollection, IDs, reflect.TypeOf(writes[0].Data))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ManifestChange, 0)
+	for _, w := range changes {
+		entry := ManifestChange{
+			Collection:      collectionName,
+			DefinitionID:    w.ID,
+			Version:         version,
+			PreviousVersion: previousVersion,
+			Fields:          make([]FieldDiff, 0),
+		}
+		old, existed := previous[w.ID]
+		switch {
+		case w.Delete:
+			entry.Change = ChangeRemoved
+			if existed {
+				entry.Name = extractKeyFields(old).Name
+			}
+		case !existed:
+			entry.Change = ChangeAdded
+			entry.Name = extractKeyFields(w.Data).Name
+		default:
+			after := extractKeyFields(w.Data)
+			entry.Fields = diffKeyFields(extractKeyFields(old), after)
+			if len(entry.Fields) == 0 {
+				continue
+			}
+			entry.Change = ChangeChanged
+			entry.Name = after.Name
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// saveChangelog stores the entries under the version's document and merges the collection's counts into its summary.
+func saveChangelog(
+	ctx context.Context,
+	db *firestore.Client,
+	collectionName string,
+	entries []ManifestChange,
+	result MigrationResult,
+	version string,
+) error {
+	versionRef := db.Collection(ManifestChangesCollection).Doc(version)
+	writes := make([]docWrite, 0, len(entries))
+	for _, entry := range entries {
+		writes = append(writes, docWrite{ID: fmt.Sprintf("%s-%s", collectionName, entry.DefinitionID), Data: entry})
+	}
+	if err := bulkWrite(ctx, db, fmt.Sprintf("%s/%s/%s", ManifestChangesCollection, version, changeEntriesCollection), writes); err != nil {
+		return fmt.Errorf("failed to save changelog entries: %w", err)
+	}
+	_, err := versionRef.Set(ctx, map[string]any{
+		"version":   version,
+		"updatedAt": time.Now(),
+		"collections": map[string]any{
+			collectionName: map[string]any{
+				"added":     result.Added,
+				"changed":   result.Changed,
+				"unchanged": result.Unchanged,
+				"removed":   result.Removed,
+				"logged":    len(entries),
+			},
+		},
+	}, firestore.MergeAll)
+	return err
+}
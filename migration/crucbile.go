@@ -1,79 +1,225 @@
 package main
 
 import (
+	"slices"
+	"sort"
 	"strings"
 )
 
-func BuildCrucibleMaps(items map[string]ActivityDefinition) (any, error) {
+// activityModeCategoryPvP is DestinyActivityModeCategory.PvP
+const activityModeCategoryPvP = 2
+
+const (
+	CategorySixVSix      = "6v6"
+	CategoryThreeVSThree = "3v3"
+	CategoryCompetitive  = "competitive"
+	CategoryTrials       = "trials"
+	CategoryPrivate      = "private"
+)
+
+// modeCategories maps DestinyActivityModeType values to the map categories they imply
+var modeCategories = map[int][]string{
+	10: {CategorySixVSix},                           // Control
+	12: {CategorySixVSix},                           // Clash
+	19: {CategorySixVSix},                           // IronBanner
+	25: {CategorySixVSix},                           // AllMayhem
+	31: {CategorySixVSix},                           // Supremacy
+	32: {CategoryPrivate},                           // PrivateMatchesAll
+	37: {CategoryThreeVSThree, CategoryCompetitive}, // Survival
+	38: {CategoryThreeVSThree},                      // Countdown
+	39: {CategoryTrials},                            // TrialsOfTheNine
+	41: {CategoryThreeVSThree, CategoryTrials},      // TrialsCountdown
+	42: {CategoryThreeVSThree, CategoryTrials},      // TrialsSurvival
+	43: {CategorySixVSix},                           // IronBannerControl
+	44: {CategorySixVSix},                           // IronBannerClash
+	45: {CategorySixVSix},                           // IronBannerSupremacy
+	51: {CategoryPrivate},                           // PrivateMatchesClash
+	52: {CategoryPrivate},                           // PrivateMatchesControl
+	53: {CategoryPrivate},                           // PrivateMatchesSupremacy
+	54: {CategoryPrivate},                           // PrivateMatchesCountdown
+	55: {CategoryPrivate},                           // PrivateMatchesSurvival
+	56: {CategoryPrivate},                           // PrivateMatchesMayhem
+	57: {CategoryPrivate},                           // PrivateMatchesRumble
+	59: {CategoryThreeVSThree},                      // Showdown
+	60: {CategorySixVSix},                           // Lockdown
+	67: {CategoryThreeVSThree},                      // Salvage
+	68: {CategorySixVSix},                           // IronBannerSalvage
+	69: {CategoryCompetitive},                       // PvPCompetitive
+	70: {CategorySixVSix},                           // PvPQuickplay
+	71: {CategorySixVSix},                           // ClashQuickplay
+	72: {CategoryCompetitive},                       // ClashCompetitive
+	73: {CategorySixVSix},                           // ControlQuickplay
+	74: {CategoryCompetitive},                       // ControlCompetitive
+	80: {CategoryThreeVSThree},                      // Elimination
+	81: {CategorySixVSix},                           // Momentum
+	84: {CategoryThreeVSThree, CategoryTrials},      // TrialsOfOsiris
+	88: {CategorySixVSix},                           // Rift
+	89: {CategorySixVSix},                           // ZoneControl
+	90: {CategorySixVSix},                           // IronBannerRift
+	91: {CategorySixVSix},                           // IronBannerZoneControl
+	92: {CategorySixVSix},                           // Relic
+}
+
+type CrucibleMap struct {
+	DisplayProperties  ActivityDisplayProperties `firestore:"displayProperties"`
+	Key                string                    `firestore:"key"`
+	Hashes             []int                     `firestore:"hashes"`
+	DestinationHashes  []int                     `firestore:"destinationHashes"`
+	PlaceHashes        []int                     `firestore:"placeHashes"`
+	ActivityModeHashes []int                     `firestore:"activityModeHashes"`
+	ActivityModeTypes  []int                     `firestore:"activityModeTypes"`
+	Categories         []string                  `firestore:"categories"`
+}
+
+// CrucibleMapIndexEntry points a single activity at the crucible map it is played on.
+type CrucibleMapIndexEntry struct {
+	ActivityHash int    `firestore:"activityHash"`
+	Key          string `firestore:"key"`
+}
+
+// BuildCrucibleMaps groups every PvP activity by the map it is played on.
+func BuildCrucibleMaps(activities map[string]ActivityDefinition, modes map[string]ActivityModeDefinition) (any, error) {
+	maps, _ := buildCrucibleMaps(activities, modes)
+	return maps, nil
+}
+
+// BuildCrucibleMapIndex maps every PvP activity hash to the key of its crucible map.
+func BuildCrucibleMapIndex(activities map[string]ActivityDefinition, modes map[string]ActivityModeDefinition) (any, error) {
+	_, index := buildCrucibleMaps(activities, modes)
+	entries := make([]CrucibleMapIndexEntry, 0, len(index))
+	for hash, key := range index {
+		entries = append(entries, CrucibleMapIndexEntry{ActivityHash: hash, Key: key})
+	}
+	return entries, nil
+}
+
+// buildCrucibleMaps returns the crucible maps keyed by map key, and the key of every activity played on one.
+//
+// Activities are named after their map, except for some that are named after the mode they play, like private
+// match or playlist variants. Named activities are grouped by map name, then activities named after a mode are
+// attached to the map sharing their destination, or place when there is no destination. Definitions are handled in
+// hash order and every list is sorted so the same manifest always builds the same documents.
+func buildCrucibleMaps(activities map[string]ActivityDefinition, modes map[string]ActivityModeDefinition) (map[string]CrucibleMap, map[int]string) {
+	modeTypes := make(map[int]int, len(modes))
+	modeNames := make(map[string]bool, len(modes))
+	for _, mode := range modes {
+		if mode.ActivityModeCategory == activityModeCategoryPvP {
+			modeTypes[int(mode.Hash)] = mode.ModeType
+		}
+		if name := format(mode.DisplayProperties.Name); name != "" {
+			modeNames[name] = true
+		}
+	}
+
+	definitions := make([]ActivityDefinition, 0)
+	for _, definition := range activities {
+		if definition.IsPvP && !definition.IsPlaylist && !definition.Redacted {
+			definitions = append(definitions, definition)
+		}
+	}
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].Hash < definitions[j].Hash })
+
 	maps := make(map[string]CrucibleMap)
-	for _, definition := range items {
-		// Combine with skip function
-		if definition.IsPvP && !definition.IsPlaylist {
-			name := format(definition.DisplayProperties.Name)
-			if skip(name) {
-				continue
-			}
-			existing, ok := maps[name]
-			if ok {
-				existing.Hashes = append(existing.Hashes, definition.Hash)
-				existing.ActivityModeHashes = append(existing.Hashes, definition.ActivityModeHashes...)
-				existing.ActivityModeTypes = append(existing.Hashes, definition.ActivityModeTypes...)
-				if !existing.DisplayProperties.HasIcon {
-					existing.DisplayProperties.Icon = definition.DisplayProperties.Icon
-					existing.DisplayProperties.HasIcon = definition.DisplayProperties.HasIcon
-				}
-				maps[name] = existing
-			} else {
-				maps[name] = CrucibleMap{
-					DisplayProperties:  definition.DisplayProperties,
-					Key:                name,
-					Hashes:             []int{definition.Hash},
-					ActivityModeHashes: definition.ActivityModeHashes,
-					ActivityModeTypes:  definition.ActivityModeTypes,
-				}
-			}
+	index := make(map[int]string)
+	byLocation := make(map[int]map[string]bool)
+	unnamed := make([]ActivityDefinition, 0)
+	for _, definition := range definitions {
+		name := mapName(definition)
+		if name == "" || modeNames[name] {
+			unnamed = append(unnamed, definition)
+			continue
+		}
+		maps[name] = addActivity(maps[name], name, definition, modeTypes)
+		index[definition.Hash] = name
+		location := mapLocation(definition)
+		if byLocation[location] == nil {
+			byLocation[location] = make(map[string]bool)
+		}
+		byLocation[location][name] = true
+	}
+	for _, definition := range unnamed {
+		keys := byLocation[mapLocation(definition)]
+		if len(keys) != 1 {
+			// Either not a map at all or a location shared by several maps
+			continue
+		}
+		for name := range keys {
+			maps[name] = addActivity(maps[name], name, definition, modeTypes)
+			index[definition.Hash] = name
 		}
 	}
-	return maps, nil
-}
 
-func format(s string) string {
-	return strings.ReplaceAll(strings.ToLower(s), " ", "-")
+	for name, crucibleMap := range maps {
+		sortUnique(&crucibleMap.Hashes)
+		sortUnique(&crucibleMap.DestinationHashes)
+		sortUnique(&crucibleMap.PlaceHashes)
+		sortUnique(&crucibleMap.ActivityModeHashes)
+		sortUnique(&crucibleMap.ActivityModeTypes)
+		sortUnique(&crucibleMap.Categories)
+		maps[name] = crucibleMap
+	}
+	return maps, index
 }
 
-func skip(value string) bool {
-	if strings.Contains(value, "private") {
-		return true
+// addActivity merges the activity into the map, creating the map when it is the first activity played on it.
+func addActivity(crucibleMap CrucibleMap, key string, definition ActivityDefinition, modeTypes map[int]int) CrucibleMap {
+	if crucibleMap.Key == "" {
+		crucibleMap = CrucibleMap{Key: key, DisplayProperties: definition.OriginalDisplayProperties, Categories: []string{}}
+		if crucibleMap.DisplayProperties.Name == "" {
+			crucibleMap.DisplayProperties = definition.DisplayProperties
+		}
 	}
-	if strings.Contains(value, "control") {
-		return true
+	if !crucibleMap.DisplayProperties.HasIcon && definition.DisplayProperties.HasIcon {
+		crucibleMap.DisplayProperties.Icon = definition.DisplayProperties.Icon
+		crucibleMap.DisplayProperties.HasIcon = true
 	}
-	if strings.Contains(value, "clash") {
-		return true
+	crucibleMap.Hashes = append(crucibleMap.Hashes, definition.Hash)
+	if definition.DestinationHash != 0 {
+		crucibleMap.DestinationHashes = append(crucibleMap.DestinationHashes, definition.DestinationHash)
 	}
-	if strings.Contains(value, "elimination") {
-		return true
+	if definition.PlaceHash != 0 {
+		crucibleMap.PlaceHashes = append(crucibleMap.PlaceHashes, definition.PlaceHash)
 	}
-	if strings.Contains(value, "iron-banner") {
-		return true
+	crucibleMap.ActivityModeHashes = append(crucibleMap.ActivityModeHashes, definition.ActivityModeHashes...)
+	crucibleMap.ActivityModeTypes = append(crucibleMap.ActivityModeTypes, definition.ActivityModeTypes...)
+
+	types := slices.Clone(definition.ActivityModeTypes)
+	for _, hash := range definition.ActivityModeHashes {
+		if modeType, ok := modeTypes[hash]; ok {
+			types = append(types, modeType)
+		}
 	}
-	if strings.Contains(value, "relic") {
-		return true
+	for _, modeType := range types {
+		crucibleMap.Categories = append(crucibleMap.Categories, modeCategories[modeType]...)
 	}
-	if strings.Contains(value, "rumble") {
-		return true
+	return crucibleMap
+}
+
+// mapName is the key of the map an activity is played on, taken from the original display properties since
+// playlists rename the activity.
+func mapName(definition ActivityDefinition) string {
+	if name := format(definition.OriginalDisplayProperties.Name); name != "" {
+		return name
 	}
-	if value == "" {
-		return true
+	return format(definition.DisplayProperties.Name)
+}
+
+func mapLocation(definition ActivityDefinition) int {
+	if definition.DestinationHash != 0 {
+		return definition.DestinationHash
 	}
-	return false
+	return definition.PlaceHash
 }
 
-type CrucibleMap struct {
-	DisplayProperties  ActivityDisplayProperties `firestore:"displayProperties"`
-	Key                string                    `firestore:"key"`
-	Hashes             []int                     `firestore:"hashes"`
-	ActivityModeHashes []int                     `firestore:"activityModeHashes"`
-	ActivityModeTypes  []int                     `firestore:"activityModeTypes"`
-	Categories         []string                  `firestore:"categories"`
+func sortUnique[T ~int | ~string](values *[]T) {
+	if *values == nil {
+		*values = []T{}
+		return
+	}
+	slices.Sort(*values)
+	*values = slices.Compact(*values)
+}
+
+func format(s string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(s)), " ", "-")
 }
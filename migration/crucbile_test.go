@@ -0,0 +1,168 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func pvpActivity(hash int, name string, destination, place int, modeHashes ...int) ActivityDefinition {
+	return ActivityDefinition{
+		Hash:                      hash,
+		IsPvP:                     true,
+		OriginalDisplayProperties: ActivityDisplayProperties{Name: name},
+		DisplayProperties:         ActivityDisplayProperties{Name: name},
+		DestinationHash:           destination,
+		PlaceHash:                 place,
+		ActivityModeHashes:        modeHashes,
+	}
+}
+
+func TestBuildCrucibleMaps(t *testing.T) {
+	modes := map[string]ActivityModeDefinition{}
+	for _, mode := range []ActivityModeDefinition{
+		{Hash: 100, ModeType: 10, ActivityModeCategory: activityModeCategoryPvP, DisplayProperties: ModeDisplayProperties{Name: "Control"}},
+		{Hash: 200, ModeType: 32, ActivityModeCategory: activityModeCategoryPvP, DisplayProperties: ModeDisplayProperties{Name: "Private Match"}},
+		{Hash: 300, ModeType: 84, ActivityModeCategory: activityModeCategoryPvP, DisplayProperties: ModeDisplayProperties{Name: "Trials of Osiris"}},
+		// Not a PvP mode, so its type doesn't add a category
+		{Hash: 400, ModeType: 10, ActivityModeCategory: 1, DisplayProperties: ModeDisplayProperties{Name: "Strikes"}},
+	} {
+		modes[strconv.FormatInt(mode.Hash, 10)] = mode
+	}
+
+	type wantMap struct {
+		hashes     []int
+		categories []string
+	}
+	tests := []struct {
+		name       string
+		activities []ActivityDefinition
+		want       map[string]wantMap
+	}{
+		{
+			name: "groups activities by original name",
+			activities: func() []ActivityDefinition {
+				playlist := pvpActivity(2, "Javelin-4", 10, 0, 100)
+				playlist.DisplayProperties.Name = "Control"
+				return []ActivityDefinition{
+					pvpActivity(1, "Javelin-4", 10, 0, 100),
+					playlist,
+					pvpActivity(3, "The Dead Cliffs", 20, 0, 100),
+				}
+			}(),
+			want: map[string]wantMap{
+				"javelin-4":       {hashes: []int{1, 2}, categories: []string{CategorySixVSix}},
+				"the-dead-cliffs": {hashes: []int{3}, categories: []string{CategorySixVSix}},
+			},
+		},
+		{
+			name: "falls back to the display name",
+			activities: func() []ActivityDefinition {
+				activity := pvpActivity(1, "", 10, 0)
+				activity.DisplayProperties.Name = "Widow's Court"
+				return []ActivityDefinition{activity}
+			}(),
+			want: map[string]wantMap{
+				"widow's-court": {hashes: []int{1}, categories: []string{}},
+			},
+		},
+		{
+			name: "attaches activities named after a mode by destination or place",
+			activities: []ActivityDefinition{
+				pvpActivity(1, "Javelin-4", 10, 0, 100),
+				pvpActivity(2, "Private Match", 10, 0, 200),
+				pvpActivity(3, "Cathedral of Dusk", 0, 30, 100),
+				pvpActivity(4, "Private Match", 0, 30, 200),
+			},
+			want: map[string]wantMap{
+				"javelin-4":         {hashes: []int{1, 2}, categories: []string{CategorySixVSix, CategoryPrivate}},
+				"cathedral-of-dusk": {hashes: []int{3, 4}, categories: []string{CategorySixVSix, CategoryPrivate}},
+			},
+		},
+		{
+			name: "leaves out activities named after a mode on an unknown or shared location",
+			activities: []ActivityDefinition{
+				pvpActivity(1, "Endless Vale", 40, 0, 100),
+				pvpActivity(2, "Altar of Flame", 40, 0, 100),
+				pvpActivity(3, "Private Match", 40, 0, 200),
+				pvpActivity(4, "Private Match", 50, 0, 200),
+				pvpActivity(5, "", 60, 0),
+			},
+			want: map[string]wantMap{
+				"endless-vale":   {hashes: []int{1}, categories: []string{CategorySixVSix}},
+				"altar-of-flame": {hashes: []int{2}, categories: []string{CategorySixVSix}},
+			},
+		},
+		{
+			name: "skips non PvP, playlist and redacted activities",
+			activities: func() []ActivityDefinition {
+				strike := pvpActivity(2, "The Arms Dealer", 10, 0)
+				strike.IsPvP = false
+				playlist := pvpActivity(3, "Quickplay", 10, 0)
+				playlist.IsPlaylist = true
+				redacted := pvpActivity(4, "Classified", 10, 0)
+				redacted.Redacted = true
+				return []ActivityDefinition{pvpActivity(1, "Javelin-4", 10, 0, 100), strike, playlist, redacted}
+			}(),
+			want: map[string]wantMap{
+				"javelin-4": {hashes: []int{1}, categories: []string{CategorySixVSix}},
+			},
+		},
+		{
+			name: "derives categories from mode hashes and mode types",
+			activities: func() []ActivityDefinition {
+				trials := pvpActivity(2, "Javelin-4", 10, 0, 300)
+				typed := pvpActivity(3, "Javelin-4", 10, 0, 400)
+				typed.ActivityModeTypes = []int{69}
+				return []ActivityDefinition{pvpActivity(1, "Javelin-4", 10, 0, 100), trials, typed}
+			}(),
+			want: map[string]wantMap{
+				"javelin-4": {
+					hashes:     []int{1, 2, 3},
+					categories: []string{CategoryThreeVSThree, CategorySixVSix, CategoryCompetitive, CategoryTrials},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activities := make(map[string]ActivityDefinition, len(tt.activities))
+			for _, activity := range tt.activities {
+				activities[strconv.Itoa(activity.Hash)] = activity
+			}
+			maps, index := buildCrucibleMaps(activities, modes)
+
+			if len(maps) != len(tt.want) {
+				t.Errorf("built %d maps, want %d: %v", len(maps), len(tt.want), maps)
+			}
+			indexed := 0
+			for key, want := range tt.want {
+				got, ok := maps[key]
+				if !ok {
+					t.Errorf("map %s is missing", key)
+					continue
+				}
+				if got.Key != key {
+					t.Errorf("map %s has key %s", key, got.Key)
+				}
+				if !slices.Equal(got.Hashes, want.hashes) {
+					t.Errorf("map %s hashes = %v, want %v", key, got.Hashes, want.hashes)
+				}
+				categories := slices.Clone(want.categories)
+				slices.Sort(categories)
+				if !slices.Equal(got.Categories, categories) {
+					t.Errorf("map %s categories = %v, want %v", key, got.Categories, categories)
+				}
+				for _, hash := range want.hashes {
+					if index[hash] != key {
+						t.Errorf("activity %d indexed as %q, want %q", hash, index[hash], key)
+					}
+				}
+				indexed += len(want.hashes)
+			}
+			if len(index) != indexed {
+				t.Errorf("indexed %d activities, want %d: %v", len(index), indexed, index)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// manifestLocale is the locale of the manifest content that is migrated
+const manifestLocale = "en"
+
+// ComponentPath returns the path of the file holding only the given table, when Bungie provides one.
+func (m *ManifestResponse) ComponentPath(locale, table string) (string, bool) {
+	path, ok := m.Response.JsonWorldComponentContentPaths[locale][table]
+	return path, ok && path != ""
+}
+
+// loadManifestTables decodes the given tables of the manifest into a single Manifest.
+func loadManifestTables(ctx context.Context, store ManifestStore, response *ManifestResponse, keys ...string) (Manifest, error) {
+	var manifest Manifest
+	for _, key := range keys {
+		if err := loadManifestTable(ctx, store, response, key, &manifest); err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// loadManifestTable decodes a single table of the manifest. The per-table component file is used when available,
+// otherwise the table is streamed out of the full world content file.
+func loadManifestTable(ctx context.Context, store ManifestStore, response *ManifestResponse, key string, manifest *Manifest) error {
+	version := response.Response.Version
+	if path, ok := response.ComponentPath(manifestLocale, key); ok {
+		body, err := openManifest(ctx, store, version, key+".json", SetBaseUrl(&path))
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		return decodeManifestComponent(body, key, manifest)
+	}
+
+	path := response.Response.JsonWorldContentPaths.EN
+	body, err := openManifest(ctx, store, version, ManifestObjectName, SetBaseUrl(&path))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	// Only the task's own table is decoded, the rest of the manifest is streamed past
+	return decodeManifestTable(body, key, manifest)
+}
+
+// decodeManifestComponent decodes a component file, which holds a single table, into the matching field of manifest.
+func decodeManifestComponent(r io.Reader, key string, manifest *Manifest) error {
+	field, ok := manifestField(manifest, key)
+	if !ok {
+		return fmt.Errorf("manifest has no field for table %s", key)
+	}
+	if err := json.NewDecoder(r).Decode(field.Addr().Interface()); err != nil {
+		return fmt.Errorf("failed to decode table %s: %w", key, err)
+	}
+	return nil
+}
+
+// decodeManifestTable walks the top level of the world content JSON and decodes only the table named key into the
+// matching field of manifest. Every other table is skipped token by token so it is never held in memory.
+func decodeManifestTable(r io.Reader, key string, manifest *Manifest) error {
+	field, ok := manifestField(manifest, key)
+	if !ok {
+		return fmt.Errorf("manifest has no field for table %s", key)
+	}
+
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	found := false
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("expected table name, got %v", token)
+		}
+		if name != key {
+			if err := skipValue(dec); err != nil {
+				return fmt.Errorf("failed to skip table %s: %w", name, err)
+			}
+			continue
+		}
+		if err := dec.Decode(field.Addr().Interface()); err != nil {
+			return fmt.Errorf("failed to decode table %s: %w", name, err)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("table %s not found in manifest", key)
+	}
+	return nil
+}
+
+// manifestField finds the field of Manifest whose json tag is the table name.
+func manifestField(manifest *Manifest, key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(manifest).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := token.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// skipValue consumes the next value, however deeply nested, without decoding it.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if d, ok := token.(json.Delim); ok {
+			switch d {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
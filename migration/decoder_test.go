@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeManifestTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		key     string
+		wantErr bool
+		// hashes of the classes expected to be decoded
+		want []int64
+	}{
+		{
+			name: "decodes the only table",
+			json: `{"DestinyClassDefinition": {"1": {"hash": 1, "classType": 0}}}`,
+			key:  "DestinyClassDefinition",
+			want: []int64{1},
+		},
+		{
+			name: "skips tables before and after",
+			json: `{
+				"DestinyRaceDefinition": {"9": {"hash": 9, "nested": [1, {"a": [true, null]}, "x"]}},
+				"DestinyClassDefinition": {"1": {"hash": 1}, "2": {"hash": 2}},
+				"DestinyDamageTypeDefinition": {"3": {"hash": 3}}
+			}`,
+			key:  "DestinyClassDefinition",
+			want: []int64{1, 2},
+		},
+		{
+			name: "skips tables the manifest has no type for",
+			json: `{"DestinyUnknownDefinition": {"5": {"weird": {"deep": [[[]]]}}}, "DestinyClassDefinition": {"1": {"hash": 1}}}`,
+			key:  "DestinyClassDefinition",
+			want: []int64{1},
+		},
+		{
+			name:    "missing table",
+			json:    `{"DestinyRaceDefinition": {"9": {"hash": 9}}}`,
+			key:     "DestinyClassDefinition",
+			wantErr: true,
+		},
+		{
+			name:    "unknown key",
+			json:    `{"DestinyClassDefinition": {}}`,
+			key:     "DestinyNotATable",
+			wantErr: true,
+		},
+		{
+			name:    "not an object",
+			json:    `[]`,
+			key:     "DestinyClassDefinition",
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			json:    `{"DestinyRaceDefinition": {"9": {"hash": 9}`,
+			key:     "DestinyClassDefinition",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var manifest Manifest
+			err := decodeManifestTable(strings.NewReader(tt.json), tt.key, &manifest)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeManifestTable returned %v", err)
+			}
+			if len(manifest.ClassDefinition) != len(tt.want) {
+				t.Fatalf("decoded %d classes, want %d", len(manifest.ClassDefinition), len(tt.want))
+			}
+			for _, hash := range tt.want {
+				found := false
+				for _, class := range manifest.ClassDefinition {
+					if class.Hash == hash {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("class %d wasn't decoded", hash)
+				}
+			}
+			if manifest.RaceDefinition != nil || manifest.DamageTypeDefinition != nil {
+				t.Error("other tables should be left empty")
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// HashIndexCollection holds, per manifest collection, the content hash of every definition last written
+	HashIndexCollection = "manifestHashes"
+	hashShardCollection = "shards"
+	// hashIndexShards splits the index so the largest collection stays well under the 1 MiB document limit
+	hashIndexShards = 16
+)
+
+// MigrationResult counts what a migration did to a collection.
+type MigrationResult struct {
+	Added     int `json:"added"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+	// Total is the number of definitions in the manifest table
+	Total int `json:"total"`
+}
+
+// hashShard is a single document of the hash index, mapping document IDs to content hashes.
+type hashShard struct {
+	Hashes map[string]string `firestore:"hashes"`
+}
+
+// contentHash is the hex sha256 of the JSON encoding of the definition. Map keys are sorted by encoding/json,
+// so the same definition always hashes the same.
+func contentHash(item any) (string, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func hashShardID(docID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(docID))
+	return strconv.Itoa(int(h.Sum32() % hashIndexShards))
+}
+
+func hashShardsRef(db *firestore.Client, collectionName string) *firestore.CollectionRef {
+	return db.Collection(HashIndexCollection).Doc(collectionName).Collection(hashShardCollection)
+}
+
+// loadHashIndex reads the stored hashes for the collection. A nil map means no index has been written yet.
+func loadHashIndex(ctx context.Context, db *firestore.Client, collectionName string) (map[string]string, error) {
+	docs, err := hashShardsRef(db, collectionName).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	index := make(map[string]string)
+	for _, doc := range docs {
+		shard := hashShard{}
+		if err := doc.DataTo(&shard); err != nil {
+			return nil, fmt.Errorf("failed to read hash shard %s: %w", doc.Ref.ID, err)
+		}
+		for ID, hash := range shard.Hashes {
+			index[ID] = hash
+		}
+	}
+	return index, nil
+}
+
+// existingDocIDs lists the document IDs currently in the collection. Used when no hash index exists yet so
+// definitions that were removed from the manifest still get deleted.
+func existingDocIDs(ctx context.Context, db *firestore.Client, collectionName string) (map[string]string, error) {
+	IDs := make(map[string]string)
+	refs := db.Collection(collectionName).DocumentRefs(ctx)
+	for {
+		ref, err := refs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// No known hash, so every existing document is treated as changed
+		IDs[ref.ID] = ""
+	}
+	return IDs, nil
+}
+
+// saveHashIndex replaces every shard of the index with the given hashes.
+func saveHashIndex(ctx context.Context, db *firestore.Client, collectionName string, index map[string]string) error {
+	shards := make(map[string]map[string]string, hashIndexShards)
+	for i := 0; i < hashIndexShards; i++ {
+		shards[strconv.Itoa(i)] = make(map[string]string)
+	}
+	for ID, hash := range index {
+		shards[hashShardID(ID)][ID] = hash
+	}
+	ref := hashShardsRef(db, collectionName)
+	for ID, hashes := range shards {
+		if _, err := ref.Doc(ID).Set(ctx, hashShard{Hashes: hashes}); err != nil {
+			return fmt.Errorf("failed to save hash shard %s: %w", ID, err)
+		}
+	}
+	return nil
+}
+
+// diffWrites compares the new definitions against the stored hashes. It returns the writes needed to bring the
+// collection up to date, including deletes for removed definitions, and the hash index to store afterwards.
+func diffWrites(writes []docWrite, previous map[string]string) ([]docWrite, map[string]string, MigrationResult, error) {
+	result := MigrationResult{Total: len(writes)}
+	next := make(map[string]string, len(writes))
+	changes := make([]docWrite, 0)
+	for _, w := range writes {
+		hash, err := contentHash(w.Data)
+		if err != nil {
+			return nil, nil, result, fmt.Errorf("failed to hash %s: %w", w.ID, err)
+		}
+		next[w.ID] = hash
+		old, ok := previous[w.ID]
+		switch {
+		case !ok:
+			result.Added++
+			changes = append(changes, w)
+		case old != hash:
+			result.Changed++
+			changes = append(changes, w)
+		default:
+			result.Unchanged++
+		}
+	}
+	for ID := range previous {
+		if _, ok := next[ID]; !ok {
+			result.Removed++
+			changes = append(changes, docWrite{ID: ID, Delete: true})
+		}
+	}
+	return changes, next, result, nil
+}
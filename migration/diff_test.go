@@ -0,0 +1,96 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func mustHash(t *testing.T, item any) string {
+	t.Helper()
+	hash, err := contentHash(item)
+	if err != nil {
+		t.Fatalf("failed to hash %v: %v", item, err)
+	}
+	return hash
+}
+
+func TestDiffWrites(t *testing.T) {
+	type def struct {
+		Name string `json:"name"`
+	}
+	tests := []struct {
+		name     string
+		writes   []docWrite
+		previous map[string]string
+		// changed IDs, deletes prefixed with "-"
+		want   []string
+		result MigrationResult
+	}{
+		{
+			name:     "first run writes everything",
+			writes:   []docWrite{{ID: "1", Data: def{"a"}}, {ID: "2", Data: def{"b"}}},
+			previous: map[string]string{},
+			want:     []string{"1", "2"},
+			result:   MigrationResult{Added: 2, Total: 2},
+		},
+		{
+			name:     "unchanged definitions are skipped",
+			writes:   []docWrite{{ID: "1", Data: def{"a"}}, {ID: "2", Data: def{"b"}}},
+			previous: map[string]string{"1": mustHash(t, def{"a"}), "2": mustHash(t, def{"b"})},
+			want:     []string{},
+			result:   MigrationResult{Unchanged: 2, Total: 2},
+		},
+		{
+			name:     "changed definitions are rewritten",
+			writes:   []docWrite{{ID: "1", Data: def{"a"}}, {ID: "2", Data: def{"c"}}},
+			previous: map[string]string{"1": mustHash(t, def{"a"}), "2": mustHash(t, def{"b"})},
+			want:     []string{"2"},
+			result:   MigrationResult{Changed: 1, Unchanged: 1, Total: 2},
+		},
+		{
+			name:     "removed definitions are deleted",
+			writes:   []docWrite{{ID: "1", Data: def{"a"}}},
+			previous: map[string]string{"1": mustHash(t, def{"a"}), "2": mustHash(t, def{"b"})},
+			want:     []string{"-2"},
+			result:   MigrationResult{Unchanged: 1, Removed: 1, Total: 1},
+		},
+		{
+			name:     "added, changed and removed together",
+			writes:   []docWrite{{ID: "1", Data: def{"z"}}, {ID: "3", Data: def{"c"}}},
+			previous: map[string]string{"1": mustHash(t, def{"a"}), "2": mustHash(t, def{"b"})},
+			want:     []string{"-2", "1", "3"},
+			result:   MigrationResult{Added: 1, Changed: 1, Removed: 1, Total: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, next, result, err := diffWrites(tt.writes, tt.previous)
+			if err != nil {
+				t.Fatalf("diffWrites returned %v", err)
+			}
+			got := make([]string, 0, len(changes))
+			for _, change := range changes {
+				if change.Delete {
+					got = append(got, "-"+change.ID)
+					continue
+				}
+				got = append(got, change.ID)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+			if result != tt.result {
+				t.Errorf("result = %+v, want %+v", result, tt.result)
+			}
+			if len(next) != len(tt.writes) {
+				t.Errorf("next index has %d hashes, want %d", len(next), len(tt.writes))
+			}
+			for _, w := range tt.writes {
+				if next[w.ID] != mustHash(t, w.Data) {
+					t.Errorf("next index hash for %s doesn't match its content", w.ID)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ go 1.24
 require (
 	cloud.google.com/go/firestore v1.18.0
 	github.com/rs/zerolog v1.34.0
+	google.golang.org/api v0.214.0
 )
 
 require (
@@ -33,7 +34,6 @@ require (
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/time v0.8.0 // indirect
-	google.golang.org/api v0.214.0 // indirect
 	google.golang.org/genproto v0.0.0-20241118233622-e639e219e697 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20241118233622-e639e219e697 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241209162323-e6fa225c2576 // indirect
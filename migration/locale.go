@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// localesFromEnv parses MIGRATION_LOCALES, a comma separated list of Bungie locales such as "de,es,pt-br".
+// English is always migrated as the default text, so it is never listed as an extra locale.
+func localesFromEnv(value string) []string {
+	locales := make([]string, 0)
+	for _, locale := range strings.Split(value, ",") {
+		locale = strings.ToLower(strings.TrimSpace(locale))
+		if locale == "" || locale == manifestLocale {
+			continue
+		}
+		locales = append(locales, locale)
+	}
+	return locales
+}
+
+// loadLocalizedDisplayProperties reads the display properties of every definition in the table for each locale,
+// keyed by definition hash and then locale. Locales without a component file for the table are skipped.
+func loadLocalizedDisplayProperties(
+	ctx context.Context,
+	store ManifestStore,
+	response *ManifestResponse,
+	key string,
+	locales []string,
+) (map[string]map[string]LocalizedDisplayProperties, error) {
+	localized := make(map[string]map[string]LocalizedDisplayProperties)
+	for _, locale := range locales {
+		l := log.With().Str("locale", locale).Str("table", key).Logger()
+		path, ok := response.ComponentPath(locale, key)
+		if !ok {
+			l.Warn().Msg("no component file for locale, skipping")
+			continue
+		}
+		body, err := openManifest(ctx, store, response.Response.Version, fmt.Sprintf("%s.%s.json", key, locale), SetBaseUrl(&path))
+		if err != nil {
+			return nil, err
+		}
+		var table map[string]struct {
+			DisplayProperties LocalizedDisplayProperties `json:"displayProperties"`
+		}
+		err = json.NewDecoder(body).Decode(&table)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s for %s: %w", key, locale, err)
+		}
+		for hash, definition := range table {
+			if definition.DisplayProperties.Name == "" && definition.DisplayProperties.Description == "" {
+				continue
+			}
+			if localized[hash] == nil {
+				localized[hash] = make(map[string]LocalizedDisplayProperties, len(locales))
+			}
+			localized[hash][locale] = definition.DisplayProperties
+		}
+		l.Info().Int("count", len(table)).Msg("loaded localized display properties")
+	}
+	return localized, nil
+}
+
+var localesType = reflect.TypeOf(map[string]LocalizedDisplayProperties{})
+
+// applyLocales sets the Locales field of every definition that has one. Definitions without the field, such as
+// crucible maps, are left as they are.
+func applyLocales(writes []docWrite, localized map[string]map[string]LocalizedDisplayProperties) {
+	if len(localized) == 0 {
+		return
+	}
+	for i, w := range writes {
+		locales, ok := localized[w.ID]
+		if !ok {
+			continue
+		}
+		v := reflect.ValueOf(w.Data)
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		field, ok := v.Type().FieldByName("Locales")
+		if !ok || field.Type != localesType {
+			continue
+		}
+		item := reflect.New(v.Type()).Elem()
+		item.Set(v)
+		item.FieldByIndex(field.Index).Set(reflect.ValueOf(locales))
+		writes[i].Data = item.Interface()
+	}
+}
@@ -17,7 +17,14 @@ import (
 
 type Config struct {
 	taskNum    int64
+	taskCount  int64
 	attemptNum string
+	// manifestDir is where downloaded manifests are shared between tasks
+	manifestDir string
+	// manifestLock is set when manifestDir supports atomic exclusive create, so tasks can take turns fetching
+	manifestLock bool
+	// locales are the extra locales whose display properties are migrated alongside English
+	locales []string
 }
 
 func SetBaseUrl(value *string) string {
@@ -34,10 +41,34 @@ func configFromEnv() (Config, error) {
 	if err != nil {
 		return Config{}, err
 	}
+	// Only set when running as a Cloud Run job
+	var taskCount int64
+	if value := os.Getenv("CLOUD_RUN_TASK_COUNT"); value != "" {
+		taskCount, err = stringToInt(value)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	manifestDir := os.Getenv("MANIFEST_STORE_DIR")
+	if manifestDir == "" {
+		manifestDir = defaultManifestStoreDir()
+	}
+	var manifestLock int64
+	if value := os.Getenv("MANIFEST_STORE_LOCK"); value != "" {
+		manifestLock, err = stringToInt(value)
+		if err != nil {
+			return Config{}, err
+		}
+	}
 
 	config := Config{
-		taskNum:    taskNum,
-		attemptNum: attemptNum,
+		taskNum:      taskNum,
+		taskCount:    taskCount,
+		attemptNum:   attemptNum,
+		manifestDir:  manifestDir,
+		manifestLock: manifestLock == 1,
+		locales:      localesFromEnv(os.Getenv("MIGRATION_LOCALES")),
 	}
 	return config, nil
 }
@@ -53,6 +84,12 @@ const (
 
 func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	if len(os.Args) > 1 {
+		if err := runCommand(context.Background(), os.Args[1:]); err != nil {
+			log.Fatal().Err(err).Msg("command failed")
+		}
+		return
+	}
 	config, err := configFromEnv()
 	if err != nil {
 		log.Fatal().Err(err)
@@ -60,6 +97,19 @@ func main() {
 	l := log.With().Int64("taskNum", config.taskNum).Logger()
 	ctx := context.Background()
 
+	if config.taskCount > 0 && config.taskCount < int64(len(registry)) {
+		l.Fatal().
+			Int64("taskCount", config.taskCount).
+			Int("collections", len(registry)).
+			Msg("not enough tasks to migrate every collection, deploy with --tasks set to the number of collections")
+	}
+	descriptor, ok := DescriptorByIndex(config.taskNum)
+	if !ok {
+		l.Info().Msg("no collection for this task index")
+		return
+	}
+	table := descriptor.VersionField
+
 	db, err := firestore.NewClient(ctx, projectID)
 	if err != nil {
 		l.Fatal().Err(err).Msgf("Failed to create client: %v", err)
@@ -71,19 +121,18 @@ func main() {
 	}
 
 	var data Configuration
-	err = snapshot.DataTo(&data)
-	if err != nil {
+	if err := snapshot.DataTo(&data); err != nil {
 		l.Fatal().Err(err).Msg("failed to read into configuration")
 	}
-	currentVersion, ok := GetVersionByIndex(data, config.taskNum)
-	if !ok {
-		l.Fatal().Msg("unknown index for version")
-	}
-	table, ok := GetConfigKeyByIndex(config.taskNum)
-	if !ok {
-		log.Fatal().Msg("unknown task index")
-	}
-	l = l.With().Str("table", table).Str("lastVersion", currentVersion).Logger()
+	// Missing on the first migration of a new collection
+	currentVersion, _ := snapshot.Data()[table].(string)
+	target := resolveTarget(data, string(descriptor.Collection))
+	l = l.With().
+		Str("table", table).
+		Str("collection", string(descriptor.Collection)).
+		Str("staging", target.Staging).
+		Str("lastVersion", currentVersion).
+		Logger()
 
 	manifestResponse, err := requestManifestInformation(ctx)
 	if err != nil {
@@ -95,52 +144,45 @@ func main() {
 		l.Info().Msg("data is up to date")
 		return
 	}
-
-	path := manifestResponse.Response.JsonWorldContentPaths.EN
-	manifestURL := SetBaseUrl(&path)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
-	if err != nil {
-		l.Fatal().Err(err).Msg("failed to create request")
+	if pinned(data, string(descriptor.Collection), version) {
+		l.Warn().Str("version", version).Msg("collection was rolled back from this version, skipping until it is unpinned")
+		return
 	}
 
-	// Add headers that might be necessary for the request
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("User-Agent", "oneTrick")
-
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	store := &LocalManifestStore{Dir: config.manifestDir, ExclusiveCreate: config.manifestLock}
+	manifest, err := loadManifestTables(ctx, store, manifestResponse, descriptor.tables()...)
 	if err != nil {
-		l.Fatal().Err(err).Msg("failed to download file:")
-	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		l.Fatal().Msgf("bad response status: %s (code: %d)", resp.Status, resp.StatusCode)
-	}
-
-	l.Info().
-		Str("url", manifestURL).
-		Msg("downloaded file from source")
-	var manifest Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
 		l.Fatal().Err(err).Msg("failed to decode manifest data")
 	}
+	localized, err := loadLocalizedDisplayProperties(ctx, store, manifestResponse, descriptor.ManifestKey, config.locales)
+	if err != nil {
+		l.Fatal().Err(err).Msg("failed to load localized display properties")
+	}
 
 	l.Debug().Msg("Decoded JSON successfully")
 
-	err = performMigration(ctx, db, manifest, config.taskNum)
+	versions := manifestVersions{Previous: currentVersion, Current: version}
+	result, err := performMigration(ctx, db, manifest, descriptor, versions, target, localized)
 	if err != nil {
 		l.Fatal().Err(err).Msg("failed to perform migration")
 	}
-	l.Info().Msg("migration completed")
-	err = updateManifestVersion(ctx, db, table, version)
+	l.Info().Any("result", result).Msg("migration completed")
+
+	// Only switch over once the staging slot holds exactly the manifest's definitions
+	count, err := countDocuments(ctx, db, target.Staging)
 	if err != nil {
-		l.Fatal().Err(err).Msg("failed to update version")
+		l.Fatal().Err(err).Msg("failed to count staged documents")
+	}
+	if count != int64(result.Total) {
+		l.Fatal().
+			Int64("staged", count).
+			Int("expected", result.Total).
+			Msg("staged collection does not match the manifest, keeping the active version")
 	}
-	l.Info().Msg("updated version")
+	if err := activateCollection(ctx, db, table, target, versions); err != nil {
+		l.Fatal().Err(err).Msg("failed to switch to the new version")
+	}
+	l.Info().Str("active", target.Staging).Msg("updated version")
 	l.Info().Msg("work done")
 }
 
@@ -187,64 +229,37 @@ func requestManifestInformation(ctx context.Context) (*ManifestResponse, error)
 	return &manifestResponse, nil
 }
 
-// GetVersionByIndex returns the version string for the given collection index
-// Returns the version string and a boolean indicating if the index was valid
-func GetVersionByIndex(configuration Configuration, index int64) (string, bool) {
-	switch index {
-	case 0:
-		return configuration.InventoryBucketVersion, true
-	case 1:
-		return configuration.ClassVersion, true
-	case 2:
-		return configuration.PlaceVersion, true
-	case 3:
-		return configuration.DamageVersion, true
-	case 4:
-		return configuration.ActivityModeVersion, true
-	case 5:
-		return configuration.ActivityVersion, true
-	case 6:
-		return configuration.ItemCategoryVersion, true
-	case 7:
-		return configuration.ItemDefinitionVersion, true
-	case 8:
-		return configuration.StatDefinitionVersion, true
-	case 9:
-		return configuration.RaceVersion, true
-	case 10:
-		return configuration.SandboxPerkVersion, true
-	case 11:
-		return configuration.RecordDefinitionVersion, true
-	case 12:
-		return configuration.CrucibleMapVersion, true
-	default:
-		return "", false
-	}
+// manifestVersions is the manifest version a collection was last migrated from and the one being migrated to.
+type manifestVersions struct {
+	Previous string
+	Current  string
 }
 
 func migrateCollection(
 	ctx context.Context,
 	db *firestore.Client,
-	collectionName string,
+	versions manifestVersions,
+	target migrationTarget,
 	itemsToMigrate interface{},
 	getDocID func(item interface{}) string,
-) error {
+	localized map[string]map[string]LocalizedDisplayProperties,
+) (MigrationResult, error) {
 	loopStartTime := time.Now()
 
 	// Use reflection to iterate over the items since they're of different types
 	val := reflect.ValueOf(itemsToMigrate)
 
 	if val.Kind() != reflect.Map && val.Kind() != reflect.Slice {
-		return fmt.Errorf("itemsToMigrate must be a map or slice, got %s", val.Kind())
+		return MigrationResult{}, fmt.Errorf("itemsToMigrate must be a map or slice, got %s", val.Kind())
 	}
 
-	count := 0
 	var keys []reflect.Value
 
 	if val.Kind() == reflect.Map {
 		keys = val.MapKeys()
 	}
 
+	writes := make([]docWrite, 0, val.Len())
 	// For maps, iterate over the keys
 	// For slices, iterate over the indices
 	for i := 0; i < val.Len(); i++ {
@@ -259,238 +274,85 @@ func migrateCollection(
 			item = val.Index(i).Interface()
 		}
 
-		// Get document ID for this item
-		docID := getDocID(item)
+		writes = append(writes, docWrite{ID: getDocID(item), Data: item})
+	}
+
+	applyLocales(writes, localized)
 
-		// Save to Firestore
-		_, err := db.Collection(collectionName).Doc(docID).Set(ctx, item)
+	// The staging slot holds the version before the active one, so only what changed since then is written
+	previous, err := loadHashIndex(ctx, db, target.Staging)
+	if err != nil {
+		return MigrationResult{}, fmt.Errorf("failed to load hash index: %w", err)
+	}
+	if previous == nil {
+		log.Info().Str("collection", target.Staging).Msg("no hash index found, rewriting the whole collection")
+		previous, err = existingDocIDs(ctx, db, target.Staging)
 		if err != nil {
-			log.Error().Str("docID", docID).Err(err).Msg("failed to save definition")
-			return err
+			return MigrationResult{}, fmt.Errorf("failed to list existing documents: %w", err)
 		}
-
-		count++
+	}
+	changes, next, result, err := diffWrites(writes, previous)
+	if err != nil {
+		return result, err
 	}
 
-	log.Info().Str("collection", collectionName).Int("count", count).Dur("duration", time.Since(loopStartTime)).Msg("finished migrating collection")
-	return nil
-}
-
-// to performMigration uses the generic migrateCollection function to handle various definition types
-func performMigration(ctx context.Context, db *firestore.Client, manifest Manifest, index int64) error {
-	switch index {
-	case 0:
-		return migrateCollection(
-			ctx,
-			db,
-			string(InventoryBucketCollection),
-			manifest.InventoryBucketDefinition,
-			func(item interface{}) string {
-				definition := item.(InventoryBucketDefinition)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-
-	case 1:
-		return migrateCollection(
-			ctx,
-			db,
-			string(ClassCollection),
-			manifest.ClassDefinition,
-			func(item interface{}) string {
-				definition := item.(ClassDefinition)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-
-	case 2:
-		return migrateCollection(
-			ctx,
-			db,
-			string(PlaceCollection),
-			manifest.PlaceDefinition,
-			func(item interface{}) string {
-				definition := item.(PlaceDefinition)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-
-	case 3:
-		return migrateCollection(
-			ctx,
-			db,
-			string(DamageCollection),
-			manifest.DamageTypeDefinition,
-			func(item interface{}) string {
-				definition := item.(DamageType)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-
-	case 4:
-		return migrateCollection(
-			ctx,
-			db,
-			string(ActivityModeCollection),
-			manifest.ActivityModeDefinition,
-			func(item interface{}) string {
-				definition := item.(ActivityModeDefinition)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-	case 5:
-		return migrateCollection(
-			ctx,
-			db,
-			string(ActivityCollection),
-			manifest.ActivityDefinition,
-			func(item interface{}) string {
-				definition := item.(ActivityDefinition)
-				return strconv.FormatInt(int64(definition.Hash), 10)
-			},
-		)
-
-	case 6:
-		return migrateCollection(
-			ctx,
-			db,
-			string(ItemCategoryCollection),
-			manifest.ItemCategoryDefinition,
-			func(item interface{}) string {
-				definition := item.(ItemCategory)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-
-	case 7:
-		return migrateCollection(
-			ctx,
-			db,
-			string(ItemDefinitionCollection),
-			manifest.InventoryItemDefinition,
-			func(item interface{}) string {
-				definition := item.(ItemDefinition)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-
-	case 8:
-		return migrateCollection(
-			ctx,
-			db,
-			string(StatDefinitionCollection),
-			manifest.StatDefinition,
-			func(item interface{}) string {
-				definition := item.(StatDefinition)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-
-	case 9:
-		return migrateCollection(
-			ctx,
-			db,
-			string(RaceCollection),
-			manifest.RaceDefinition,
-			func(item interface{}) string {
-				definition := item.(RaceDefinition)
-				return strconv.FormatInt(int64(definition.Hash), 10)
-			},
-		)
-
-	case 10:
-		return migrateCollection(
-			ctx,
-			db,
-			string(SandboxPerkCollection),
-			manifest.SandboxPerkDefinition,
-			func(item interface{}) string {
-				definition := item.(PerkDefinition)
-				return strconv.FormatInt(definition.Hash, 10)
-			},
-		)
-
-	case 11:
-		return migrateCollection(
-			ctx,
-			db,
-			string(RecordDefinitionCollection),
-			manifest.RecordDefinition,
-			func(item interface{}) string {
-				definition := item.(RecordDefinition)
-				return strconv.FormatInt(int64(definition.Hash), 10)
-			},
-		)
-	case 12:
-		{
-			maps, err := BuildCrucibleMaps(manifest.ActivityDefinition)
-			if err != nil {
-				return err
-			}
-			return migrateCollection(
-				ctx,
-				db,
-				string(CrucibleMapCollection),
-				maps,
-				func(item any) string {
-					definition := item.(CrucibleMap)
-					return definition.Key
-				},
-			)
-
+	// The changelog compares against the version being served. Without an index every existing document would
+	// count as changed, so there is nothing meaningful to log
+	active, err := loadHashIndex(ctx, db, target.Active)
+	if err != nil {
+		return result, fmt.Errorf("failed to load active hash index: %w", err)
+	}
+	var changelog []ManifestChange
+	var changelogResult MigrationResult
+	if active != nil {
+		var activeChanges []docWrite
+		activeChanges, _, changelogResult, err = diffWrites(writes, active)
+		if err != nil {
+			return result, err
+		}
+		changelog, err = buildChangelog(ctx, db, target.Base, target.Active, writes, activeChanges, active, versions.Previous, versions.Current)
+		if err != nil {
+			return result, fmt.Errorf("failed to build changelog: %w", err)
 		}
-	default:
-		log.Info().Int64("index", index).Msg("Unsupported index")
-		return nil
 	}
-}
 
-// GetConfigKeyByIndex returns the configuration key name as a string for a given index
-// Returns the key name and a boolean indicating if the index was valid
-func GetConfigKeyByIndex(index int64) (string, bool) {
-	switch index {
-	case 0:
-		return "inventoryBucketVersion", true
-	case 1:
-		return "classVersion", true
-	case 2:
-		return "placeVersion", true
-	case 3:
-		return "damageVersion", true
-	case 4:
-		return "activityModeVersion", true
-	case 5:
-		return "activityVersion", true
-	case 6:
-		return "itemCategoryVersion", true
-	case 7:
-		return "itemDefinitionVersion", true
-	case 8:
-		return "statDefinitionVersion", true
-	case 9:
-		return "raceVersion", true
-	case 10:
-		return "sandboxPerkVersion", true
-	case 11:
-		return "recordDefinitionVersion", true
-	case 12:
-		return "crucibleMapVersion", true
-	default:
-		return "", false
+	if err := bulkWrite(ctx, db, target.Staging, changes); err != nil {
+		return result, err
+	}
+	// Saved after the documents so a failed run leaves the old hashes behind and rewrites the changes next time
+	if err := saveHashIndex(ctx, db, target.Staging, next); err != nil {
+		return result, err
+	}
+	if active != nil {
+		if err := saveChangelog(ctx, db, target.Base, changelog, changelogResult, versions.Current); err != nil {
+			return result, err
+		}
 	}
+
+	log.Info().
+		Str("collection", target.Staging).
+		Int("added", result.Added).
+		Int("changed", result.Changed).
+		Int("unchanged", result.Unchanged).
+		Int("removed", result.Removed).
+		Dur("duration", time.Since(loopStartTime)).
+		Msg("finished migrating collection")
+	return result, nil
 }
-func updateManifestVersion(ctx context.Context, db *firestore.Client, table, version string) error {
-	_, err := db.
-		Collection(ConfigurationCollection).
-		Doc(DestinyDocument).
-		Set(
-			ctx, map[string]interface{}{
-				table: version,
-			}, firestore.MergeAll,
-		)
+
+// performMigration migrates the definitions of a single registry entry
+func performMigration(
+	ctx context.Context,
+	db *firestore.Client,
+	manifest Manifest,
+	descriptor CollectionDescriptor,
+	versions manifestVersions,
+	target migrationTarget,
+	localized map[string]map[string]LocalizedDisplayProperties,
+) (MigrationResult, error) {
+	items, err := descriptor.Items(manifest)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to update config")
-		return err
+		return MigrationResult{}, err
 	}
-	return nil
+	return migrateCollection(ctx, db, versions, target, items, descriptor.DocID, localized)
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrManifestNotStored is returned by a ManifestStore when the file for the version has not been fetched yet.
+var ErrManifestNotStored = errors.New("manifest not stored")
+
+// ErrManifestLocked is returned by ManifestStore.Lock when another task is already fetching the file.
+var ErrManifestLocked = errors.New("manifest is being fetched by another task")
+
+// ErrManifestLockUnsupported is returned by ManifestStore.Lock when the store can't guarantee a single holder, so
+// every task fetches its own copy.
+var ErrManifestLockUnsupported = errors.New("manifest store can't be locked")
+
+const (
+	// manifestLockTTL is how long a lock is honored. A task that died mid download leaves its lock behind, so older
+	// locks are taken over
+	manifestLockTTL = 15 * time.Minute
+	// manifestWaitTimeout is how long a task waits for another to finish fetching before downloading itself
+	manifestWaitTimeout  = 10 * time.Minute
+	manifestPollInterval = 2 * time.Second
+)
+
+// ManifestStore keeps downloaded manifest files keyed by manifest version so every task reads the same copy
+// instead of downloading it again.
+type ManifestStore interface {
+	Open(ctx context.Context, version, name string) (io.ReadCloser, error)
+	Put(ctx context.Context, version, name string, r io.Reader) error
+	// Lock claims the fetch of a file so only one task downloads it. It returns ErrManifestLocked when another task
+	// holds the claim, otherwise a func that releases it.
+	Lock(ctx context.Context, version, name string) (func(), error)
+}
+
+// LocalManifestStore stores manifests under Dir/{version}/{name}. On Cloud Run Dir is the destiny bucket mounted
+// as a volume, which shares the files between tasks and executions.
+type LocalManifestStore struct {
+	Dir string
+	// ExclusiveCreate is set when Dir is on a filesystem where an exclusive create succeeds for exactly one caller.
+	// GCS FUSE doesn't guarantee it, so the bucket mount leaves it off and Lock is never attempted
+	ExclusiveCreate bool
+}
+
+// defaultManifestStoreDir is where the destiny bucket is mounted
+func defaultManifestStoreDir() string {
+	return filepath.Dir("/" + mntLocation)
+}
+
+func (s *LocalManifestStore) path(version, name string) string {
+	return filepath.Join(s.Dir, version, name)
+}
+
+func (s *LocalManifestStore) Open(_ context.Context, version, name string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(version, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrManifestNotStored
+	}
+	return f, err
+}
+
+func (s *LocalManifestStore) Put(_ context.Context, version, name string, r io.Reader) error {
+	dir := filepath.Join(s.Dir, version)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Tasks fetch concurrently, so write to a temp file and rename to never expose a partial manifest
+	tmp, err := os.CreateTemp(dir, name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(version, name))
+}
+
+// Lock creates a {name}.lock marker next to the file. The marker is created exclusively, so only the first task to
+// get there becomes the fetcher. Without ExclusiveCreate it returns ErrManifestLockUnsupported.
+func (s *LocalManifestStore) Lock(_ context.Context, version, name string) (func(), error) {
+	if !s.ExclusiveCreate {
+		return nil, ErrManifestLockUnsupported
+	}
+	dir := filepath.Join(s.Dir, version)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := s.path(version, name) + ".lock"
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < manifestLockTTL {
+			return nil, ErrManifestLocked
+		}
+		log.Warn().Str("lock", path).Msg("taking over stale manifest lock")
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrManifestLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// openManifest returns the stored manifest file for the version, fetching it into the store first when missing.
+// Only the task holding the store's lock downloads it; the others wait for the stored copy. A store that can't be
+// locked has every task fetch and store its own copy. If the store can't be used, or the wait runs out, the file is
+// streamed straight from Bungie instead.
+func openManifest(ctx context.Context, store ManifestStore, version, name, url string) (io.ReadCloser, error) {
+	l := log.With().Str("version", version).Str("name", name).Logger()
+	r, err := store.Open(ctx, version, name)
+	if err == nil {
+		l.Info().Msg("using stored manifest")
+		return r, nil
+	}
+	if !errors.Is(err, ErrManifestNotStored) {
+		l.Warn().Err(err).Msg("failed to open stored manifest, downloading instead")
+		return downloadManifest(ctx, url)
+	}
+
+	release, err := store.Lock(ctx, version, name)
+	if errors.Is(err, ErrManifestLocked) {
+		l.Info().Msg("another task is fetching the manifest, waiting for it")
+		r, err := waitForManifest(ctx, store, version, name)
+		if err != nil {
+			l.Warn().Err(err).Msg("stored manifest never appeared, downloading instead")
+			return downloadManifest(ctx, url)
+		}
+		l.Info().Msg("using manifest stored by another task")
+		return r, nil
+	}
+	if errors.Is(err, ErrManifestLockUnsupported) {
+		// Every task fetches its own copy. Put replaces the file whole, so the copies don't clash and later runs still
+		// reuse the stored one
+		l.Info().Msg("manifest store can't be locked, fetching this task's own copy")
+		release = func() {}
+	} else if err != nil {
+		l.Warn().Err(err).Msg("failed to lock manifest store, downloading instead")
+		return downloadManifest(ctx, url)
+	}
+	defer release()
+	// Another task may have stored it between the first check and taking the lock
+	if r, err := store.Open(ctx, version, name); err == nil {
+		return r, nil
+	}
+
+	body, err := downloadManifest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	err = store.Put(ctx, version, name, body)
+	body.Close()
+	if err != nil {
+		l.Warn().Err(err).Msg("failed to store manifest, downloading instead")
+		return downloadManifest(ctx, url)
+	}
+	l.Info().Str("url", url).Msg("stored manifest")
+	return store.Open(ctx, version, name)
+}
+
+// waitForManifest polls the store until the file appears or manifestWaitTimeout passes.
+func waitForManifest(ctx context.Context, store ManifestStore, version, name string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestWaitTimeout)
+	defer cancel()
+	ticker := time.NewTicker(manifestPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		r, err := store.Open(ctx, version, name)
+		if err == nil {
+			return r, nil
+		}
+		if !errors.Is(err, ErrManifestNotStored) {
+			return nil, err
+		}
+	}
+}
+
+func downloadManifest(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add headers that might be necessary for the request
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("User-Agent", "oneTrick")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("bad response status: %s (code: %d)", resp.Status, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
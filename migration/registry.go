@@ -0,0 +1,217 @@
+package main
+
+import (
+	"strconv"
+)
+
+// CollectionDescriptor describes how a single manifest table is migrated. Each Cloud Run task migrates the
+// descriptor at its task index in the registry.
+type CollectionDescriptor struct {
+	// ManifestKey is the name of the table in the manifest
+	ManifestKey string
+	// Collection is the Firestore collection the definitions are written to
+	Collection ManifestCollection
+	// VersionField is the field of the destiny configuration document holding the last migrated version
+	VersionField string
+	// Dependencies are other manifest tables Items reads, such as the activity modes used to categorize crucible maps
+	Dependencies []string
+	// Items returns the definitions to write, as a map or slice. Transforms such as BuildCrucibleMaps happen here
+	Items func(manifest Manifest) (any, error)
+	// DocID returns the document ID of a definition returned by Items
+	DocID func(item any) string
+}
+
+// tables returns every manifest table the descriptor needs decoded.
+func (d CollectionDescriptor) tables() []string {
+	return append([]string{d.ManifestKey}, d.Dependencies...)
+}
+
+// byHash builds a DocID func for definitions identified by their hash.
+func byHash[T any](hash func(T) int64) func(any) string {
+	return func(item any) string {
+		return strconv.FormatInt(hash(item.(T)), 10)
+	}
+}
+
+// registry is every collection the migration job keeps up to date. Order matters: an entry's position is the
+// CLOUD_RUN_TASK_INDEX that migrates it, so new entries are appended at the end.
+var registry = []CollectionDescriptor{
+	{
+		ManifestKey:  "DestinyInventoryBucketDefinition",
+		Collection:   InventoryBucketCollection,
+		VersionField: "inventoryBucketVersion",
+		Items:        func(m Manifest) (any, error) { return m.InventoryBucketDefinition, nil },
+		DocID:        byHash(func(d InventoryBucketDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyClassDefinition",
+		Collection:   ClassCollection,
+		VersionField: "classVersion",
+		Items:        func(m Manifest) (any, error) { return m.ClassDefinition, nil },
+		DocID:        byHash(func(d ClassDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyPlaceDefinition",
+		Collection:   PlaceCollection,
+		VersionField: "placeVersion",
+		Items:        func(m Manifest) (any, error) { return m.PlaceDefinition, nil },
+		DocID:        byHash(func(d PlaceDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyDamageTypeDefinition",
+		Collection:   DamageCollection,
+		VersionField: "damageVersion",
+		Items:        func(m Manifest) (any, error) { return m.DamageTypeDefinition, nil },
+		DocID:        byHash(func(d DamageType) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyActivityModeDefinition",
+		Collection:   ActivityModeCollection,
+		VersionField: "activityModeVersion",
+		Items:        func(m Manifest) (any, error) { return m.ActivityModeDefinition, nil },
+		DocID:        byHash(func(d ActivityModeDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyActivityDefinition",
+		Collection:   ActivityCollection,
+		VersionField: "activityVersion",
+		Items:        func(m Manifest) (any, error) { return m.ActivityDefinition, nil },
+		DocID:        byHash(func(d ActivityDefinition) int64 { return int64(d.Hash) }),
+	},
+	{
+		ManifestKey:  "DestinyItemCategoryDefinition",
+		Collection:   ItemCategoryCollection,
+		VersionField: "itemCategoryVersion",
+		Items:        func(m Manifest) (any, error) { return m.ItemCategoryDefinition, nil },
+		DocID:        byHash(func(d ItemCategory) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyInventoryItemDefinition",
+		Collection:   ItemDefinitionCollection,
+		VersionField: "itemDefinitionVersion",
+		Items:        func(m Manifest) (any, error) { return m.InventoryItemDefinition, nil },
+		DocID:        byHash(func(d ItemDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyStatDefinition",
+		Collection:   StatDefinitionCollection,
+		VersionField: "statDefinitionVersion",
+		Items:        func(m Manifest) (any, error) { return m.StatDefinition, nil },
+		DocID:        byHash(func(d StatDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyRaceDefinition",
+		Collection:   RaceCollection,
+		VersionField: "raceVersion",
+		Items:        func(m Manifest) (any, error) { return m.RaceDefinition, nil },
+		DocID:        byHash(func(d RaceDefinition) int64 { return int64(d.Hash) }),
+	},
+	{
+		ManifestKey:  "DestinySandboxPerkDefinition",
+		Collection:   SandboxPerkCollection,
+		VersionField: "sandboxPerkVersion",
+		Items:        func(m Manifest) (any, error) { return m.SandboxPerkDefinition, nil },
+		DocID:        byHash(func(d PerkDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyRecordDefinition",
+		Collection:   RecordDefinitionCollection,
+		VersionField: "recordDefinitionVersion",
+		Items:        func(m Manifest) (any, error) { return m.RecordDefinition, nil },
+		DocID:        byHash(func(d RecordDefinition) int64 { return int64(d.Hash) }),
+	},
+	{
+		ManifestKey:  "DestinyActivityDefinition",
+		Collection:   CrucibleMapCollection,
+		VersionField: "crucibleMapVersion",
+		Dependencies: []string{"DestinyActivityModeDefinition"},
+		Items: func(m Manifest) (any, error) {
+			return BuildCrucibleMaps(m.ActivityDefinition, m.ActivityModeDefinition)
+		},
+		DocID: func(item any) string { return item.(CrucibleMap).Key },
+	},
+	{
+		ManifestKey:  "DestinyPlugSetDefinition",
+		Collection:   PlugSetCollection,
+		VersionField: "plugSetVersion",
+		Items:        func(m Manifest) (any, error) { return m.PlugSetDefinition, nil },
+		DocID:        byHash(func(d PlugSetDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinySocketTypeDefinition",
+		Collection:   SocketTypeCollection,
+		VersionField: "socketTypeVersion",
+		Items:        func(m Manifest) (any, error) { return m.SocketTypeDefinition, nil },
+		DocID:        byHash(func(d SocketTypeDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinySocketCategoryDefinition",
+		Collection:   SocketCategoryCollection,
+		VersionField: "socketCategoryVersion",
+		Items:        func(m Manifest) (any, error) { return m.SocketCategoryDefinition, nil },
+		DocID:        byHash(func(d SocketCategoryDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyStatGroupDefinition",
+		Collection:   StatGroupCollection,
+		VersionField: "statGroupVersion",
+		Items:        func(m Manifest) (any, error) { return m.StatGroupDefinition, nil },
+		DocID:        byHash(func(d StatGroupDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinySeasonDefinition",
+		Collection:   SeasonCollection,
+		VersionField: "seasonVersion",
+		Items:        func(m Manifest) (any, error) { return m.SeasonDefinition, nil },
+		DocID:        byHash(func(d SeasonDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyCollectibleDefinition",
+		Collection:   CollectibleCollection,
+		VersionField: "collectibleVersion",
+		Items:        func(m Manifest) (any, error) { return m.CollectibleDefinition, nil },
+		DocID:        byHash(func(d CollectibleDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyTraitDefinition",
+		Collection:   TraitCollection,
+		VersionField: "traitVersion",
+		Items:        func(m Manifest) (any, error) { return m.TraitDefinition, nil },
+		DocID:        byHash(func(d TraitDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyItemTierTypeDefinition",
+		Collection:   ItemTierTypeCollection,
+		VersionField: "itemTierTypeVersion",
+		Items:        func(m Manifest) (any, error) { return m.ItemTierTypeDefinition, nil },
+		DocID:        byHash(func(d ItemTierTypeDefinition) int64 { return d.Hash }),
+	},
+	{
+		ManifestKey:  "DestinyActivityDefinition",
+		Collection:   CrucibleMapIndexCollection,
+		VersionField: "crucibleMapIndexVersion",
+		Dependencies: []string{"DestinyActivityModeDefinition"},
+		Items: func(m Manifest) (any, error) {
+			return BuildCrucibleMapIndex(m.ActivityDefinition, m.ActivityModeDefinition)
+		},
+		DocID: byHash(func(d CrucibleMapIndexEntry) int64 { return int64(d.ActivityHash) }),
+	},
+}
+
+// DescriptorByIndex returns the registry entry migrated by the given task index.
+func DescriptorByIndex(index int64) (CollectionDescriptor, bool) {
+	if index < 0 || index >= int64(len(registry)) {
+		return CollectionDescriptor{}, false
+	}
+	return registry[index], true
+}
+
+// DescriptorByCollection returns the registry entry that migrates the named collection.
+func DescriptorByCollection(collection string) (CollectionDescriptor, bool) {
+	for _, descriptor := range registry {
+		if string(descriptor.Collection) == collection {
+			return descriptor, true
+		}
+	}
+	return CollectionDescriptor{}, false
+}
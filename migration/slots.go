@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// activeCollectionsField is the field of the destiny configuration document mapping each collection to the
+	// slot currently served
+	activeCollectionsField = "activeCollections"
+	// stagingSuffix names the second slot of a collection. The first slot is the collection itself
+	stagingSuffix = "_b"
+)
+
+// ErrActiveChanged is returned when another execution switched the active slot while this one was migrating.
+var ErrActiveChanged = errors.New("active collection changed during migration")
+
+// ActiveCollection points a collection at the slot holding the currently served version. Every collection has two
+// slots: migrations write into the inactive one and switch over once it is complete, so the previous version stays
+// untouched and can be restored by switching back.
+type ActiveCollection struct {
+	Collection         string `firestore:"collection" json:"collection"`
+	Version            string `firestore:"version" json:"version"`
+	PreviousCollection string `firestore:"previousCollection" json:"previousCollection"`
+	PreviousVersion    string `firestore:"previousVersion" json:"previousVersion"`
+	// PinnedFrom is the version a rollback switched away from. Migrations to it are skipped until the pin is cleared,
+	// otherwise the next run would switch straight back to it
+	PinnedFrom string    `firestore:"pinnedFrom" json:"pinnedFrom,omitempty"`
+	SwitchedAt time.Time `firestore:"switchedAt" json:"switchedAt"`
+}
+
+// pinned reports whether a rollback pinned the collection away from the version.
+func pinned(config Configuration, base, version string) bool {
+	current, ok := config.ActiveCollections[base]
+	return ok && current.PinnedFrom != "" && current.PinnedFrom == version
+}
+
+// migrationTarget is where a migration reads from and writes to.
+type migrationTarget struct {
+	// Base is the collection name the definitions are known by
+	Base string
+	// Active is the slot currently served
+	Active string
+	// Staging is the slot being written
+	Staging string
+}
+
+// resolveTarget picks the staging slot for the collection. Collections migrated before slots existed are served
+// from the base collection.
+func resolveTarget(config Configuration, base string) migrationTarget {
+	active := base
+	if current, ok := config.ActiveCollections[base]; ok && current.Collection != "" {
+		active = current.Collection
+	}
+	return migrationTarget{Base: base, Active: active, Staging: otherSlot(base, active)}
+}
+
+func otherSlot(base, slot string) string {
+	if slot == base {
+		return base + stagingSuffix
+	}
+	return base
+}
+
+// countDocuments returns the number of documents in the collection.
+func countDocuments(ctx context.Context, db *firestore.Client, collection string) (int64, error) {
+	result, err := db.Collection(collection).NewAggregationQuery().WithCount("all").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := result["all"]
+	if !ok {
+		return 0, fmt.Errorf("no count returned for %s", collection)
+	}
+	value, ok := count.(interface{ GetIntegerValue() int64 })
+	if !ok {
+		return 0, fmt.Errorf("unexpected count type %T", count)
+	}
+	return value.GetIntegerValue(), nil
+}
+
+// activateCollection points the collection at the staging slot and records the new version in one transaction.
+// It fails with ErrActiveChanged when the active slot is no longer the one the migration started from.
+func activateCollection(ctx context.Context, db *firestore.Client, versionField string, target migrationTarget, versions manifestVersions) error {
+	ref := db.Collection(ConfigurationCollection).Doc(DestinyDocument)
+	return db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var config Configuration
+		if err := snapshot.DataTo(&config); err != nil {
+			return err
+		}
+		if resolveTarget(config, target.Base).Active != target.Active {
+			return ErrActiveChanged
+		}
+		return tx.Set(ref, map[string]any{
+			versionField: versions.Current,
+			activeCollectionsField: map[string]any{
+				target.Base: ActiveCollection{
+					Collection:         target.Staging,
+					Version:            versions.Current,
+					PreviousCollection: target.Active,
+					PreviousVersion:    versions.Previous,
+					SwitchedAt:         time.Now(),
+				},
+			},
+		}, firestore.MergeAll)
+	})
+}
+
+// rollbackCollection switches the collection back to the slot holding the previous version and pins it away from the
+// version it left. Running it twice switches forward again and clears the pin.
+func rollbackCollection(ctx context.Context, db *firestore.Client, descriptor CollectionDescriptor) (*ActiveCollection, error) {
+	base := string(descriptor.Collection)
+	ref := db.Collection(ConfigurationCollection).Doc(DestinyDocument)
+	var restored ActiveCollection
+	err := db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var config Configuration
+		if err := snapshot.DataTo(&config); err != nil {
+			return err
+		}
+		current, ok := config.ActiveCollections[base]
+		if !ok || current.PreviousCollection == "" {
+			return fmt.Errorf("no previous version recorded for %s", base)
+		}
+		restored = ActiveCollection{
+			Collection:         current.PreviousCollection,
+			Version:            current.PreviousVersion,
+			PreviousCollection: current.Collection,
+			PreviousVersion:    current.Version,
+			PinnedFrom:         current.Version,
+			SwitchedAt:         time.Now(),
+		}
+		// Switching back to the version that was pinned undoes the rollback
+		if current.PinnedFrom == current.PreviousVersion {
+			restored.PinnedFrom = ""
+		}
+		return tx.Set(ref, map[string]any{
+			descriptor.VersionField: restored.Version,
+			activeCollectionsField: map[string]any{
+				base: restored,
+			},
+		}, firestore.MergeAll)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+// unpinCollection clears the pin a rollback left so the next run migrates the pinned version again.
+func unpinCollection(ctx context.Context, db *firestore.Client, descriptor CollectionDescriptor) (string, error) {
+	base := string(descriptor.Collection)
+	ref := db.Collection(ConfigurationCollection).Doc(DestinyDocument)
+	var cleared string
+	err := db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var config Configuration
+		if err := snapshot.DataTo(&config); err != nil {
+			return err
+		}
+		current, ok := config.ActiveCollections[base]
+		if !ok || current.PinnedFrom == "" {
+			return fmt.Errorf("%s is not pinned", base)
+		}
+		cleared = current.PinnedFrom
+		return tx.Set(ref, map[string]any{
+			activeCollectionsField: map[string]any{
+				base: map[string]any{"pinnedFrom": ""},
+			},
+		}, firestore.MergeAll)
+	})
+	return cleared, err
+}
+
+// runCommand runs a maintenance command instead of a migration task.
+func runCommand(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <collection>", args[0])
+	}
+	descriptor, ok := DescriptorByCollection(args[1])
+	if !ok {
+		return fmt.Errorf("unknown collection %q", args[1])
+	}
+	switch args[0] {
+	case "rollback", "unpin":
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	db, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "rollback":
+		restored, err := rollbackCollection(ctx, db, descriptor)
+		if err != nil {
+			return err
+		}
+		log.Info().
+			Str("collection", args[1]).
+			Str("active", restored.Collection).
+			Str("version", restored.Version).
+			Str("pinnedFrom", restored.PinnedFrom).
+			Msg("rolled back collection")
+	case "unpin":
+		cleared, err := unpinCollection(ctx, db, descriptor)
+		if err != nil {
+			return err
+		}
+		log.Info().
+			Str("collection", args[1]).
+			Str("version", cleared).
+			Msg("unpinned collection, the next run migrates this version again")
+	}
+	return nil
+}
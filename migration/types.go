@@ -17,7 +17,7 @@ type Manifest struct {
 	InventoryBucketDefinition                map[string]InventoryBucketDefinition `json:"DestinyInventoryBucketDefinition"`
 	RaceDefinition                           map[string]RaceDefinition            `json:"DestinyRaceDefinition"`
 	UnlockDefinition                         map[string]any                       `json:"DestinyUnlockDefinition"`
-	StatGroupDefinition                      map[string]any                       `json:"DestinyStatGroupDefinition"`
+	StatGroupDefinition                      map[string]StatGroupDefinition       `json:"DestinyStatGroupDefinition"`
 	ProgressionMappingDefinition             map[string]any                       `json:"DestinyProgressionMappingDefinition"`
 	FactionDefinition                        map[string]any                       `json:"DestinyFactionDefinition"`
 	VendorGroupDefinition                    map[string]any                       `json:"DestinyVendorGroupDefinition"`
@@ -35,7 +35,7 @@ type Manifest struct {
 	BondDefinition                           map[string]any                       `json:"DestinyBondDefinition"`
 	CharacterCustomizationCategoryDefinition map[string]any                       `json:"DestinyCharacterCustomizationCategoryDefinition"`
 	CharacterCustomizationOptionDefinition   map[string]any                       `json:"DestinyCharacterCustomizationOptionDefinition"`
-	CollectibleDefinition                    map[string]any                       `json:"DestinyCollectibleDefinition"`
+	CollectibleDefinition                    map[string]CollectibleDefinition     `json:"DestinyCollectibleDefinition"`
 	DestinationDefinition                    map[string]any                       `json:"DestinyDestinationDefinition"`
 	EntitlementOfferDefinition               map[string]any                       `json:"DestinyEntitlementOfferDefinition"`
 	EquipmentSlotDefinition                  map[string]any                       `json:"DestinyEquipmentSlotDefinition"`
@@ -49,7 +49,7 @@ type Manifest struct {
 	StatDefinition                           map[string]StatDefinition            `json:"DestinyStatDefinition"`
 	InventoryItemDefinition                  map[string]ItemDefinition            `json:"DestinyInventoryItemDefinition"`
 	InventoryItemLiteDefinition              map[string]any                       `json:"DestinyInventoryItemLiteDefinition"`
-	ItemTierTypeDefinition                   map[string]any                       `json:"DestinyItemTierTypeDefinition"`
+	ItemTierTypeDefinition                   map[string]ItemTierTypeDefinition    `json:"DestinyItemTierTypeDefinition"`
 	LoadoutColorDefinition                   map[string]any                       `json:"DestinyLoadoutColorDefinition"`
 	LoadoutIconDefinition                    map[string]any                       `json:"DestinyLoadoutIconDefinition"`
 	LoadoutNameDefinition                    map[string]any                       `json:"DestinyLoadoutNameDefinition"`
@@ -60,7 +60,7 @@ type Manifest struct {
 	ObjectiveDefinition                      map[string]any                       `json:"DestinyObjectiveDefinition"`
 	SandboxPerkDefinition                    map[string]PerkDefinition            `json:"DestinySandboxPerkDefinition"`
 	PlatformBucketMappingDefinition          map[string]any                       `json:"DestinyPlatformBucketMappingDefinition"`
-	PlugSetDefinition                        map[string]any                       `json:"DestinyPlugSetDefinition"`
+	PlugSetDefinition                        map[string]PlugSetDefinition         `json:"DestinyPlugSetDefinition"`
 	PowerCapDefinition                       map[string]any                       `json:"DestinyPowerCapDefinition"`
 	PresentationNodeDefinition               map[string]any                       `json:"DestinyPresentationNodeDefinition"`
 	ProgressionDefinition                    map[string]any                       `json:"DestinyProgressionDefinition"`
@@ -71,12 +71,12 @@ type Manifest struct {
 	RewardItemListDefinition                 map[string]any                       `json:"DestinyRewardItemListDefinition"`
 	SackRewardItemListDefinition             map[string]any                       `json:"DestinySackRewardItemListDefinition"`
 	SandboxPatternDefinition                 map[string]any                       `json:"DestinySandboxPatternDefinition"`
-	SeasonDefinition                         map[string]any                       `json:"DestinySeasonDefinition"`
+	SeasonDefinition                         map[string]SeasonDefinition          `json:"DestinySeasonDefinition"`
 	SeasonPassDefinition                     map[string]any                       `json:"DestinySeasonPassDefinition"`
 	SocialCommendationDefinition             map[string]any                       `json:"DestinySocialCommendationDefinition"`
-	SocketCategoryDefinition                 map[string]any                       `json:"DestinySocketCategoryDefinition"`
-	SocketTypeDefinition                     map[string]any                       `json:"DestinySocketTypeDefinition"`
-	TraitDefinition                          map[string]any                       `json:"DestinyTraitDefinition"`
+	SocketCategoryDefinition                 map[string]SocketCategoryDefinition  `json:"DestinySocketCategoryDefinition"`
+	SocketTypeDefinition                     map[string]SocketTypeDefinition      `json:"DestinySocketTypeDefinition"`
+	TraitDefinition                          map[string]TraitDefinition           `json:"DestinyTraitDefinition"`
 	UnlockCountMappingDefinition             map[string]any                       `json:"DestinyUnlockCountMappingDefinition"`
 	UnlockEventDefinition                    map[string]any                       `json:"DestinyUnlockEventDefinition"`
 	UnlockExpressionMappingDefinition        map[string]any                       `json:"DestinyUnlockExpressionMappingDefinition"`
@@ -203,6 +203,17 @@ type ItemDefinition struct {
 	TraitHashes                []int64               `json:"traitHashes" firestore:"traitHashes"`
 	Redacted                   bool                  `json:"redacted" firestore:"redacted"`
 	Blacklisted                bool                  `json:"blacklisted" firestore:"blacklisted"`
+	Sockets                    *ItemSockets          `json:"sockets" firestore:"sockets"`
+	SeasonHash                 int64                 `json:"seasonHash" firestore:"seasonHash"`
+	CollectibleHash            int64                 `json:"collectibleHash" firestore:"collectibleHash"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
+}
+
+// LocalizedDisplayProperties is the translated name and description of a definition.
+type LocalizedDisplayProperties struct {
+	Name        string `json:"name" firestore:"name"`
+	Description string `json:"description" firestore:"description"`
 }
 
 type ItemDisplayProperties struct {
@@ -258,6 +269,156 @@ type InvestmentStat struct {
 	Value                 int   `json:"value" firestore:"value"`
 	IsConditionallyActive bool  `json:"isConditionallyActive" firestore:"isConditionallyActive"`
 }
+type ItemSockets struct {
+	SocketEntries    []SocketEntry     `json:"socketEntries" firestore:"socketEntries"`
+	IntrinsicSockets []IntrinsicSocket `json:"intrinsicSockets" firestore:"intrinsicSockets"`
+	SocketCategories []ItemSocketGroup `json:"socketCategories" firestore:"socketCategories"`
+}
+
+type SocketEntry struct {
+	SocketTypeHash        int64 `json:"socketTypeHash" firestore:"socketTypeHash"`
+	SingleInitialItemHash int64 `json:"singleInitialItemHash" firestore:"singleInitialItemHash"`
+	ReusablePlugSetHash   int64 `json:"reusablePlugSetHash" firestore:"reusablePlugSetHash"`
+	RandomizedPlugSetHash int64 `json:"randomizedPlugSetHash" firestore:"randomizedPlugSetHash"`
+	DefaultVisible        bool  `json:"defaultVisible" firestore:"defaultVisible"`
+	PlugSources           int   `json:"plugSources" firestore:"plugSources"`
+}
+
+type IntrinsicSocket struct {
+	PlugItemHash   int64 `json:"plugItemHash" firestore:"plugItemHash"`
+	SocketTypeHash int64 `json:"socketTypeHash" firestore:"socketTypeHash"`
+	DefaultVisible bool  `json:"defaultVisible" firestore:"defaultVisible"`
+}
+
+// ItemSocketGroup lists the indexes into SocketEntries that belong to a socket category, e.g. the weapon perks.
+type ItemSocketGroup struct {
+	SocketCategoryHash int64 `json:"socketCategoryHash" firestore:"socketCategoryHash"`
+	SocketIndexes      []int `json:"socketIndexes" firestore:"socketIndexes"`
+}
+
+type PlugSetDefinition struct {
+	Hash              int64                 `json:"hash" firestore:"hash"`
+	Index             int                   `json:"index" firestore:"index"`
+	DisplayProperties ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	ReusablePlugItems []PlugSetItem         `json:"reusablePlugItems" firestore:"reusablePlugItems"`
+	IsFakePlugSet     bool                  `json:"isFakePlugSet" firestore:"isFakePlugSet"`
+	Redacted          bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted       bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+type PlugSetItem struct {
+	PlugItemHash     int64   `json:"plugItemHash" firestore:"plugItemHash"`
+	CurrentlyCanRoll bool    `json:"currentlyCanRoll" firestore:"currentlyCanRoll"`
+	Weight           float64 `json:"weight" firestore:"weight"`
+	AlternateWeight  float64 `json:"alternateWeight" firestore:"alternateWeight"`
+}
+
+type SocketTypeDefinition struct {
+	Hash                            int64                 `json:"hash" firestore:"hash"`
+	Index                           int                   `json:"index" firestore:"index"`
+	DisplayProperties               ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	PlugWhitelist                   []PlugWhitelistEntry  `json:"plugWhitelist" firestore:"plugWhitelist"`
+	SocketCategoryHash              int64                 `json:"socketCategoryHash" firestore:"socketCategoryHash"`
+	Visibility                      int                   `json:"visibility" firestore:"visibility"`
+	AlwaysRandomizeSockets          bool                  `json:"alwaysRandomizeSockets" firestore:"alwaysRandomizeSockets"`
+	IsPreviewEnabled                bool                  `json:"isPreviewEnabled" firestore:"isPreviewEnabled"`
+	HideDuplicateReusablePlugs      bool                  `json:"hideDuplicateReusablePlugs" firestore:"hideDuplicateReusablePlugs"`
+	OverridesUiAppearance           bool                  `json:"overridesUiAppearance" firestore:"overridesUiAppearance"`
+	AvoidDuplicatesOnInitialization bool                  `json:"avoidDuplicatesOnInitialization" firestore:"avoidDuplicatesOnInitialization"`
+	Redacted                        bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted                     bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+type PlugWhitelistEntry struct {
+	CategoryHash       int64  `json:"categoryHash" firestore:"categoryHash"`
+	CategoryIdentifier string `json:"categoryIdentifier" firestore:"categoryIdentifier"`
+}
+
+type SocketCategoryDefinition struct {
+	Hash              int64                 `json:"hash" firestore:"hash"`
+	Index             int                   `json:"index" firestore:"index"`
+	DisplayProperties ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	UICategoryStyle   int64                 `json:"uiCategoryStyle" firestore:"uiCategoryStyle"`
+	CategoryStyle     int                   `json:"categoryStyle" firestore:"categoryStyle"`
+	Redacted          bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted       bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+type StatGroupDefinition struct {
+	Hash         int64              `json:"hash" firestore:"hash"`
+	Index        int                `json:"index" firestore:"index"`
+	MaximumValue int                `json:"maximumValue" firestore:"maximumValue"`
+	UIPosition   int                `json:"uiPosition" firestore:"uiPosition"`
+	ScaledStats  []StatDisplayScale `json:"scaledStats" firestore:"scaledStats"`
+	Redacted     bool               `json:"redacted" firestore:"redacted"`
+	Blacklisted  bool               `json:"blacklisted" firestore:"blacklisted"`
+}
+
+// StatDisplayScale maps an investment value to the displayed stat value by interpolating between the points.
+type StatDisplayScale struct {
+	StatHash             int64                `json:"statHash" firestore:"statHash"`
+	MaximumValue         int                  `json:"maximumValue" firestore:"maximumValue"`
+	DisplayAsNumeric     bool                 `json:"displayAsNumeric" firestore:"displayAsNumeric"`
+	DisplayInterpolation []InterpolationPoint `json:"displayInterpolation" firestore:"displayInterpolation"`
+}
+
+type InterpolationPoint struct {
+	Value  int `json:"value" firestore:"value"`
+	Weight int `json:"weight" firestore:"weight"`
+}
+
+type SeasonDefinition struct {
+	Hash                     int64                 `json:"hash" firestore:"hash"`
+	Index                    int                   `json:"index" firestore:"index"`
+	DisplayProperties        ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	BackgroundImagePath      string                `json:"backgroundImagePath" firestore:"backgroundImagePath"`
+	SeasonNumber             int                   `json:"seasonNumber" firestore:"seasonNumber"`
+	StartDate                string                `json:"startDate" firestore:"startDate"`
+	EndDate                  string                `json:"endDate" firestore:"endDate"`
+	SeasonPassHash           int64                 `json:"seasonPassHash" firestore:"seasonPassHash"`
+	ArtifactItemHash         int64                 `json:"artifactItemHash" firestore:"artifactItemHash"`
+	SealPresentationNodeHash int64                 `json:"sealPresentationNodeHash" firestore:"sealPresentationNodeHash"`
+	Redacted                 bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted              bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+type CollectibleDefinition struct {
+	Hash                 int64                 `json:"hash" firestore:"hash"`
+	Index                int                   `json:"index" firestore:"index"`
+	DisplayProperties    ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	Scope                int                   `json:"scope" firestore:"scope"`
+	SourceString         string                `json:"sourceString" firestore:"sourceString"`
+	SourceHash           int64                 `json:"sourceHash" firestore:"sourceHash"`
+	ItemHash             int64                 `json:"itemHash" firestore:"itemHash"`
+	PresentationNodeType int                   `json:"presentationNodeType" firestore:"presentationNodeType"`
+	ParentNodeHashes     []int64               `json:"parentNodeHashes" firestore:"parentNodeHashes"`
+	Redacted             bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted          bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+type TraitDefinition struct {
+	Hash              int64                 `json:"hash" firestore:"hash"`
+	Index             int                   `json:"index" firestore:"index"`
+	DisplayProperties ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	DisplayHint       string                `json:"displayHint" firestore:"displayHint"`
+	Redacted          bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted       bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+type ItemTierTypeDefinition struct {
+	Hash              int64                 `json:"hash" firestore:"hash"`
+	Index             int                   `json:"index" firestore:"index"`
+	DisplayProperties ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	InfusionProcess   InfusionProcess       `json:"infusionProcess" firestore:"infusionProcess"`
+	Redacted          bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted       bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+type InfusionProcess struct {
+	BaseQualityTransferRatio float64 `json:"baseQualityTransferRatio" firestore:"baseQualityTransferRatio"`
+	MinimumQualityIncrement  int     `json:"minimumQualityIncrement" firestore:"minimumQualityIncrement"`
+}
+
 type ActivityDefinition struct {
 	ActivityLightLevel        int                       `json:"activityLightLevel" firestore:"activityLightLevel"`
 	ActivityLocationMappings  []any                     `json:"activityLocationMappings" firestore:"activityLocationMappings"`
@@ -290,6 +451,8 @@ type ActivityDefinition struct {
 	Rewards                   []any                     `json:"rewards" firestore:"rewards"`
 	SuppressOtherRewards      bool                      `json:"suppressOtherRewards" firestore:"suppressOtherRewards"`
 	Tier                      int                       `json:"tier" firestore:"tier"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 
 type ActivityDisplayProperties struct {
@@ -316,6 +479,8 @@ type PerkDefinition struct {
 	DamageTypeHash    int64                       `json:"damageTypeHash" firestore:"damageTypeHash"`
 	Redacted          bool                        `json:"redacted" firestore:"redacted"`
 	Blacklisted       bool                        `json:"blacklisted" firestore:"blacklisted"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 
 type DamageTypeDisplayProperties struct {
@@ -336,6 +501,8 @@ type StatDefinition struct {
 	Interpolate       bool                  `json:"interpolate" firestore:"interpolate"`
 	Redacted          bool                  `json:"redacted" firestore:"redacted"`
 	Blacklisted       bool                  `json:"blacklisted" firestore:"blacklisted"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 
 type StatDisplayProperties struct {
@@ -360,6 +527,8 @@ type DamageType struct {
 	Index               int                     `json:"index" firestore:"index"`
 	Redacted            bool                    `json:"redacted" firestore:"redacted"`
 	Blacklisted         bool                    `json:"blacklisted" firestore:"blacklisted"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 type DamageDisplayProperties struct {
 	Description string `json:"description" firestore:"description"`
@@ -497,6 +666,8 @@ type ActivityModeDefinition struct {
 	Index                 int                   `json:"index" firestore:"index"`
 	Redacted              bool                  `json:"redacted" firestore:"redacted"`
 	Blacklisted           bool                  `json:"blacklisted" firestore:"blacklisted"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 
 type ManifestResponse struct {
@@ -528,22 +699,13 @@ type ContentPaths struct {
 	FR string `json:"fr"`
 }
 
+// Configuration is the destiny configuration document. Each collection's last migrated version is stored in the field
+// named by its descriptor's VersionField and read from the document data.
 type Configuration struct {
 	ManifestVersion string `json:"manifestVersion" firestore:"manifestVersion"`
 
-	PlaceVersion            string `json:"placeVersion" firestore:"placeVersion"`
-	ActivityVersion         string `json:"activityVersion" firestore:"activityVersion"`
-	ClassVersion            string `json:"classVersion" firestore:"classVersion"`
-	InventoryBucketVersion  string `json:"inventoryBucketVersion" firestore:"inventoryBucketVersion"`
-	RaceVersion             string `json:"raceVersion" firestore:"raceVersion"`
-	ItemCategoryVersion     string `json:"itemCategoryVersion" firestore:"itemCategoryVersion"`
-	DamageVersion           string `json:"damageVersion" firestore:"damageVersion"`
-	ActivityModeVersion     string `json:"activityModeVersion" firestore:"activityModeVersion"`
-	StatDefinitionVersion   string `json:"statDefinitionVersion" firestore:"statDefinitionVersion"`
-	ItemDefinitionVersion   string `json:"itemDefinitionVersion" firestore:"itemDefinitionVersion"`
-	SandboxPerkVersion      string `json:"sandboxPerkVersion" firestore:"sandboxPerkVersion"`
-	RecordDefinitionVersion string `json:"recordDefinitionVersion" firestore:"recordDefinitionVersion"`
-	CrucibleMapVersion      string `json:"crucibleMapVersion" firestore:"crucibleMapVersion"`
+	// ActiveCollections maps each collection to the slot serving its current version
+	ActiveCollections map[string]ActiveCollection `json:"activeCollections" firestore:"activeCollections"`
 }
 
 type WeaponBucket = uint32
@@ -606,5 +768,14 @@ const (
 	ItemDefinitionCollection   ManifestCollection = "d2ItemDefinitions"
 	SandboxPerkCollection      ManifestCollection = "d2SandboxPerks"
 	RecordDefinitionCollection ManifestCollection = "d2RecordDefinitions"
+	PlugSetCollection          ManifestCollection = "d2PlugSets"
+	SocketTypeCollection       ManifestCollection = "d2SocketTypes"
+	SocketCategoryCollection   ManifestCollection = "d2SocketCategories"
+	StatGroupCollection        ManifestCollection = "d2StatGroups"
+	SeasonCollection           ManifestCollection = "d2Seasons"
+	CollectibleCollection      ManifestCollection = "d2Collectibles"
+	TraitCollection            ManifestCollection = "d2Traits"
+	ItemTierTypeCollection     ManifestCollection = "d2ItemTierTypes"
 	CrucibleMapCollection      ManifestCollection = "crucibleMaps"
+	CrucibleMapIndexCollection ManifestCollection = "crucibleMapIndex"
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"serverTick/bungie"
@@ -9,9 +10,13 @@ import (
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
+// ErrNoActivities is returned when a page of activity history is empty, either because the character has not
+// played any PvP or because the page is past the end of their history.
+var ErrNoActivities = errors.New("no activities found")
+
 func GetAllPVP(ctx context.Context, client *bungie.ClientWithResponses, db *firestore.Client, membershipID string, membershipType int64, characterID string, count int64, page int64) (
 	[]ActivityHistory,
 	error,
@@ -48,10 +53,14 @@ func GetAllPVP(ctx context.Context, client *bungie.ClientWithResponses, db *fire
 		return nil, fmt.Errorf("no response found")
 	}
 	if resp.JSON200.Response.Activities == nil {
-		return nil, fmt.Errorf("no definitions found")
+		return nil, ErrNoActivities
 	}
 
-	source := *resp.JSON200.Response.Activities
+	return HistoriesFromPeriods(ctx, db, *resp.JSON200.Response.Activities)
+}
+
+// HistoriesFromPeriods resolves the manifest definitions for each period and transforms them into activity histories.
+func HistoriesFromPeriods(ctx context.Context, db *firestore.Client, source []bungie.StatsPeriodGroup) ([]ActivityHistory, error) {
 	var (
 		hashes         = make([]int64, 0)
 		directorHashes = make([]int64, 0)
@@ -84,7 +93,12 @@ func GetAllPVP(ctx context.Context, client *bungie.ClientWithResponses, db *fire
 		return nil, err
 	}
 
-	return TransformPeriodGroups(*resp.JSON200.Response.Activities, definitions, directorDefinitions, modes), nil
+	mapKeys, err := GetCrucibleMapKeysByIDs(ctx, db, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return TransformPeriodGroups(ctx, source, definitions, directorDefinitions, modes, mapKeys), nil
 }
 
 type ActivityHistory struct {
@@ -105,6 +119,9 @@ type ActivityHistory struct {
 	IsPrivate  *bool  `firestore:"isPrivate" json:"isPrivate,omitempty"`
 	Location   string `firestore:"location" json:"location"`
 
+	// MapKey Key of the crucibleMaps document for the map the activity was played on, empty outside the crucible
+	MapKey string `firestore:"mapKey,omitempty" json:"mapKey,omitempty"`
+
 	// Mode Name
 	Mode        *string   `firestore:"mode" json:"mode,omitempty"`
 	Period      time.Time `firestore:"period" json:"period"`
@@ -122,7 +139,7 @@ func setBaseBungieURL(value *string) string {
 	return fmt.Sprintf("%s%s", "https://www.bungie.net", *value)
 }
 
-func GetLoadout(ctx context.Context, db *firestore.Client, client *bungie.ClientWithResponses, membershipID int64, membershipType int64, characterID string) (Loadout, map[string]ClassStat, *time.Time, error) {
+func GetLoadout(ctx context.Context, db *firestore.Client, client *bungie.ClientWithResponses, membershipID int64, membershipType int64, characterID, locale string) (Loadout, map[string]ClassStat, *time.Time, error) {
 	var components []int32
 	components = append(components, CharactersEquipment, CharactersCode)
 	params := &bungie.Destiny2GetProfileParams{
@@ -175,23 +192,24 @@ func GetLoadout(ctx context.Context, db *firestore.Client, client *bungie.Client
 
 	}
 
+	l := zerolog.Ctx(ctx)
 	statDefinitions, err := GetStats(ctx, db)
 	if err != nil {
-		log.Warn().Err(err).Msg("failed to get statDefinitions but still will generate stats")
+		l.Warn().Err(err).Msg("failed to get statDefinitions but still will generate stats")
 	}
 	stats := make(map[string]ClassStat)
 	if test.JSON200.Response.Characters.Data != nil {
 		characters := *test.JSON200.Response.Characters.Data
 		for ID, character := range characters {
 			if characterID == ID && character.Stats != nil {
-				stats = generateClassStats(statDefinitions, *character.Stats)
+				stats = generateClassStats(ctx, statDefinitions, *character.Stats)
 			}
 		}
 
 	}
-	loadout, err := buildLoadout(ctx, db, client, membershipID, membershipType, results, statDefinitions)
+	loadout, err := buildLoadout(ctx, db, client, membershipID, membershipType, results, statDefinitions, locale)
 	if err != nil {
-		log.Error().Err(err).Msg("couldn't build the loadout")
+		l.Error().Err(err).Msg("couldn't build the loadout")
 		return nil, nil, nil, err
 	}
 	return loadout, stats, timeStamp, nil
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"serverTick/bungie"
@@ -10,7 +11,7 @@ import (
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 	"google.golang.org/api/iterator"
 )
 
@@ -34,25 +35,95 @@ func GetAggregatesByActivity(ctx context.Context, db *firestore.Client, activity
 	return results, nil
 }
 
-func GetPerformances(ctx context.Context, client *bungie.ClientWithResponses, db *firestore.Client, activityID string, characterID string) (map[string]InstancePerformance, error) {
+// GetCharacterAggregates returns every aggregate the character played in since from, oldest first.
+func GetCharacterAggregates(ctx context.Context, db *firestore.Client, characterID string, from time.Time) ([]Aggregate, error) {
+	docs, err := db.
+		Collection(aggregateCollection).
+		Where("characterIds", "array-contains", characterID).
+		Where("activityHistory.period", ">=", from).
+		OrderBy("activityHistory.period", firestore.Asc).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return utils.GetAllToStructs[Aggregate](docs)
+}
+
+func GetPerformances(ctx context.Context, client *bungie.ClientWithResponses, cache PGCRCache, db *firestore.Client, activityID string, characterID string) (map[string]InstancePerformance, error) {
+	data, err := GetPostGameCarnageReport(ctx, client, cache, activityID)
+	if err != nil {
+		return nil, err
+	}
+	return PerformancesFromReport(ctx, db, data, characterID), nil
+}
+
+// GetPostGameCarnageReport fetches the post game carnage report for the activity instance.
+// Reports are read from and written to the cache when one is provided, so each instance is only fetched once.
+func GetPostGameCarnageReport(ctx context.Context, client *bungie.ClientWithResponses, cache PGCRCache, activityID string) (*bungie.PostGameCarnageReportData, error) {
 	id, err := strconv.ParseInt(activityID, 10, 64)
 	if err != nil {
 		return nil, fmt.Errorf("invalid activity ID: %w", err)
 	}
 
-	l := log.With().Str("activityId", activityID).Logger()
+	ctx = withActivityLogger(ctx, activityID)
+	l := zerolog.Ctx(ctx)
+
+	if cache != nil {
+		payload, err := cache.Get(ctx, activityID)
+		switch {
+		case err == nil:
+			data, err := decodeReport(payload)
+			if err == nil {
+				l.Debug().Msg("using cached post game carnage report")
+				return data, nil
+			}
+			l.Warn().Err(err).Msg("failed to decode cached report, fetching it again")
+		case !errors.Is(err, ErrCacheMiss):
+			l.Warn().Err(err).Msg("failed to read post game carnage report cache")
+		}
+	}
 
 	resp, err := client.Destiny2GetPostGameCarnageReportWithResponse(ctx, id)
 	if err != nil {
 		l.Error().Err(err).Msg("Failed to get post game carnage report")
 		return nil, err
 	}
-	data := resp.JSON200.PostGameCarnageReportData
-	if data.Entries == nil || data.ActivityDetails == nil {
-		l.Error().Msg("No data found for activity")
+	if resp.JSON200 == nil {
+		l.Error().Int("statusCode", resp.StatusCode()).Msg("No report found for activity")
+		return nil, fmt.Errorf("no response found")
+	}
+	data, err := reportData(resp.JSON200)
+	if err != nil {
+		l.Error().Err(err).Msg("No data found for activity")
+		return nil, err
+	}
+	if cache != nil {
+		if err := cache.Put(ctx, activityID, resp.Body); err != nil {
+			l.Warn().Err(err).Msg("failed to cache post game carnage report")
+		}
+	}
+	return data, nil
+}
+
+// decodeReport decodes a raw post game carnage report payload as returned by Bungie.
+func decodeReport(payload []byte) (*bungie.PostGameCarnageReportData, error) {
+	var resp bungie.DestinyHistoricalStatsDestinyPostGameCarnageReportData
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return nil, err
+	}
+	return reportData(&resp)
+}
+
+func reportData(resp *bungie.DestinyHistoricalStatsDestinyPostGameCarnageReportData) (*bungie.PostGameCarnageReportData, error) {
+	data := resp.PostGameCarnageReportData
+	if data == nil || data.Entries == nil || data.ActivityDetails == nil {
 		return nil, fmt.Errorf("nil data response")
 	}
+	return data, nil
+}
 
+// PerformancesFromReport builds the instance performance for the character out of a post game carnage report.
+func PerformancesFromReport(ctx context.Context, db *firestore.Client, data *bungie.PostGameCarnageReportData, characterID string) map[string]InstancePerformance {
 	performances := make(map[string]InstancePerformance)
 	items := buildItemsSet(ctx, db, data, characterID)
 	for _, entry := range *data.Entries {
@@ -68,30 +139,48 @@ func GetPerformances(ctx context.Context, client *bungie.ClientWithResponses, db
 		}
 	}
 
-	return performances, nil
+	return performances
 }
 
-func SetAggregate(ctx context.Context, db *firestore.Client, userID string, characterID string, activity ActivityHistory, period time.Time, performance InstancePerformance, sessionID string) (*Aggregate, error) {
+func SetAggregate(ctx context.Context, db *firestore.Client, w Writer, userID string, characterID string, activity ActivityHistory, period time.Time, performance InstancePerformance, sessionID *string) (*Aggregate, error) {
 	snap, link, err := FindBestFit(ctx, db, userID, characterID, period, performance.Weapons)
 	if err != nil {
 		return nil, err
 	}
 
-	enrichedPerformance, err := EnrichInstancePerformance(snap, performance)
+	enrichedPerformance, err := EnrichInstancePerformance(ctx, snap, performance)
 	if err != nil {
 		return nil, fmt.Errorf("failed to enrich performance instance: %w", err)
 	}
 
-	link.SessionID = &sessionID
+	link.SessionID = sessionID
 
-	agg, err := AddAggregate(ctx, db, characterID, activity, *link, *enrichedPerformance)
+	var (
+		previousLink        *SnapshotLink
+		previousPerformance *InstancePerformance
+	)
+	existing, err := GetAggregatesByActivity(ctx, db, []string{activity.InstanceID})
 	if err != nil {
 		return nil, err
 	}
+	if len(existing) > 0 {
+		previousLink = LookupLink(&existing[0], characterID)
+		if p, ok := existing[0].Performance[characterID]; ok {
+			previousPerformance = &p
+		}
+	}
+
+	agg, err := AddAggregate(ctx, db, w, characterID, activity, *link, *enrichedPerformance)
+	if err != nil {
+		return nil, err
+	}
+	if err := UpdateSnapshotPerformance(ctx, db, w, previousLink, previousPerformance, *link, *enrichedPerformance); err != nil {
+		return nil, err
+	}
 	return agg, nil
 }
 
-func AddAggregate(ctx context.Context, db *firestore.Client, characterID string, history ActivityHistory, snapshotLink SnapshotLink, performance InstancePerformance) (*Aggregate, error) {
+func AddAggregate(ctx context.Context, db *firestore.Client, w Writer, characterID string, history ActivityHistory, snapshotLink SnapshotLink, performance InstancePerformance) (*Aggregate, error) {
 	now := time.Now()
 	sessionIDs := make([]string, 0)
 	snapshotIDs := make([]string, 0)
@@ -107,6 +196,7 @@ func AddAggregate(ctx context.Context, db *firestore.Client, characterID string,
 	aggregate := Aggregate{
 		ActivityID:      history.InstanceID,
 		ActivityDetails: history,
+		MapKey:          history.MapKey,
 		SnapshotLinks: map[string]SnapshotLink{
 			characterID: snapshotLink,
 		},
@@ -141,17 +231,23 @@ func AddAggregate(ctx context.Context, db *firestore.Client, characterID string,
 	}
 	if existingAggregate != nil {
 		// Partial update, adding the new data
-		_, err := db.Collection(aggregateCollection).Doc(existingAggregate.ID).Set(ctx, map[string]any{
+		update := map[string]any{
 			"snapshotLinks": map[string]any{
 				characterID: snapshotLink,
 			},
 			"performance": map[string]any{
 				characterID: performance,
 			},
-			"sessionIds":   firestore.ArrayUnion(toInterfaceSlice(sessionIDs)...),
-			"snapshotIds":  firestore.ArrayUnion(toInterfaceSlice(snapshotIDs)...),
-			"characterIds": firestore.ArrayUnion(toInterfaceSlice(characterIDs)...),
-		}, firestore.MergeAll)
+			"sessionIds":   ArrayUnion(toInterfaceSlice(sessionIDs)...),
+			"snapshotIds":  ArrayUnion(toInterfaceSlice(snapshotIDs)...),
+			"characterIds": ArrayUnion(toInterfaceSlice(characterIDs)...),
+		}
+		if existingAggregate.MapKey == "" && history.MapKey != "" {
+			// Aggregates created before map keys were resolved pick it up on their next link
+			update["mapKey"] = history.MapKey
+			existingAggregate.MapKey = history.MapKey
+		}
+		err := w.Set(ctx, db.Collection(aggregateCollection).Doc(existingAggregate.ID), update, firestore.MergeAll)
 		if err != nil {
 			return nil, err
 		}
@@ -162,7 +258,7 @@ func AddAggregate(ctx context.Context, db *firestore.Client, characterID string,
 		// Create new Doc and return object
 		ref := db.Collection(aggregateCollection).NewDoc()
 		aggregate.ID = ref.ID
-		_, err := ref.Set(ctx, aggregate)
+		err := w.Set(ctx, ref, aggregate)
 		if err != nil {
 			return nil, err
 		}
@@ -218,15 +314,17 @@ func buildItemsSet(ctx context.Context, db *firestore.Client, data *bungie.PostG
 }
 
 type Aggregate struct {
-	ActivityDetails ActivityHistory                `firestore:"activityHistory" json:"activityDetails"`
-	ActivityID      string                         `firestore:"activityId" json:"activityId"`
-	CreatedAt       time.Time                      `firestore:"createdAt" json:"createdAt"`
-	ID              string                         `firestore:"id" json:"id"`
-	Performance     map[string]InstancePerformance `firestore:"performance" json:"performance"`
-	SnapshotLinks   map[string]SnapshotLink        `firestore:"snapshotLinks" json:"snapshotLinks"`
-	SnapshotIDs     []string                       `firestore:"snapshotIds" json:"snapshotIds"`
-	SessionIDs      []string                       `firestore:"sessionIds" json:"sessionIds"`
-	CharacterIDs    []string                       `firestore:"characterIds" json:"characterIds"`
+	ActivityDetails ActivityHistory `firestore:"activityHistory" json:"activityDetails"`
+	ActivityID      string          `firestore:"activityId" json:"activityId"`
+	CreatedAt       time.Time       `firestore:"createdAt" json:"createdAt"`
+	ID              string          `firestore:"id" json:"id"`
+	// MapKey Copy of ActivityDetails.MapKey so aggregates can be filtered by crucible map
+	MapKey        string                         `firestore:"mapKey,omitempty" json:"mapKey,omitempty"`
+	Performance   map[string]InstancePerformance `firestore:"performance" json:"performance"`
+	SnapshotLinks map[string]SnapshotLink        `firestore:"snapshotLinks" json:"snapshotLinks"`
+	SnapshotIDs   []string                       `firestore:"snapshotIds" json:"snapshotIds"`
+	SessionIDs    []string                       `firestore:"sessionIds" json:"sessionIds"`
+	CharacterIDs  []string                       `firestore:"characterIds" json:"characterIds"`
 }
 type InstancePerformance struct {
 	Extra *map[string]UniqueStatValue `firestore:"extra" json:"extra,omitempty"`
@@ -257,6 +355,9 @@ type ItemProperties struct {
 	// Sockets Information about the sockets of the item: which are currently active, what potential sockets you could have and the stats/abilities/perks you can gain from them. COMPONENT TYPE: ItemSockets
 	Sockets *[]Socket `firestore:"sockets" json:"sockets,omitempty"`
 
+	// PerkColumns Every perk that can roll in each of the weapon's perk sockets, from the item's plug sets.
+	PerkColumns []PerkColumn `firestore:"perkColumns" json:"perkColumns,omitempty"`
+
 	// Stats Information about the computed stats of the item: power, defense, etc... COMPONENT TYPE: ItemStats
 	Stats Stats `firestore:"stats" json:"stats"`
 }
@@ -272,6 +373,15 @@ type BaseItemInfo struct {
 	TierTypeName               string        `firestore:"tierTypeName" json:"tierTypeName"`
 	TierType                   int           `firestore:"tierType" json:"tierType"`
 	StyleBasicInfo             *BaseItemInfo `firestore:"styleBasicInfo" json:"styleBasicInfo"`
+	// Season The season the item was introduced in, when the manifest records one
+	Season *ItemSeason `firestore:"season" json:"season,omitempty"`
+}
+
+// ItemSeason is the season of origin of an item.
+type ItemSeason struct {
+	Hash   int64  `firestore:"hash" json:"hash"`
+	Name   string `firestore:"name" json:"name"`
+	Number int    `firestore:"number" json:"number"`
 }
 type DamageInfo struct {
 	Color           Color  `firestore:"color" json:"color"`
@@ -312,6 +422,23 @@ type Socket struct {
 	PlugHash int `firestore:"plugHash" json:"plugHash"`
 }
 
+// PerkColumn is one of the weapon's perk sockets with every perk its plug set holds.
+type PerkColumn struct {
+	// SocketIndex Index of the socket on the item
+	SocketIndex int `firestore:"socketIndex" json:"socketIndex"`
+	// EquippedHash The plug hash currently in the socket
+	EquippedHash *int64       `firestore:"equippedHash" json:"equippedHash,omitempty"`
+	Perks        []PerkOption `firestore:"perks" json:"perks"`
+}
+
+type PerkOption struct {
+	Hash     int64   `firestore:"hash" json:"hash"`
+	Name     string  `firestore:"name" json:"name"`
+	IconPath *string `firestore:"iconPath" json:"iconPath,omitempty"`
+	// CanRoll Whether the perk still drops on new copies of the weapon
+	CanRoll bool `firestore:"canRoll" json:"canRoll"`
+}
+
 type Stats map[string]GunStat
 type GunStat struct {
 	Description string `firestore:"description" json:"description"`
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"serverTick/bungie"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	backfillCollection = "backfills"
+	// MaxActivityPageSize is the largest page Bungie will return from the activity history endpoint
+	MaxActivityPageSize = 250
+)
+
+// BackfillCheckpoint tracks how far a backfill for a single character has gotten so an interrupted import can resume.
+type BackfillCheckpoint struct {
+	ID          string    `firestore:"id" json:"id"`
+	UserID      string    `firestore:"userId" json:"userId"`
+	CharacterID string    `firestore:"characterId" json:"characterId"`
+	From        time.Time `firestore:"from" json:"from"`
+	To          time.Time `firestore:"to" json:"to"`
+
+	// LastPeriod Start of the oldest activity handled so far. History is newest first and new games shift every page,
+	// so a resumed backfill pages from the start and skips everything from this period on instead of keeping a page
+	LastPeriod *time.Time `firestore:"lastPeriod" json:"lastPeriod,omitempty"`
+	// LastInstanceID Instance ID of the activity at LastPeriod
+	LastInstanceID string `firestore:"lastInstanceId" json:"lastInstanceId,omitempty"`
+
+	// Processed Number of activities that were linked to an aggregate so far
+	Processed int64 `firestore:"processed" json:"processed"`
+
+	// Skipped Number of activities in range that were already linked or had no performance
+	Skipped   int64     `firestore:"skipped" json:"skipped"`
+	Completed bool      `firestore:"completed" json:"completed"`
+	UpdatedAt time.Time `firestore:"updatedAt" json:"updatedAt"`
+}
+
+func backfillID(userID, characterID string) string {
+	return fmt.Sprintf("%s-%s", userID, characterID)
+}
+
+// GetBackfillCheckpoint returns the stored checkpoint for the character, or nil if none exists.
+func GetBackfillCheckpoint(ctx context.Context, db *firestore.Client, userID, characterID string) (*BackfillCheckpoint, error) {
+	doc, err := db.Collection(backfillCollection).Doc(backfillID(userID, characterID)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := BackfillCheckpoint{}
+	if err := doc.DataTo(&checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func saveBackfillCheckpoint(ctx context.Context, db *firestore.Client, w Writer, checkpoint *BackfillCheckpoint) error {
+	checkpoint.UpdatedAt = time.Now()
+	return w.Set(ctx, db.Collection(backfillCollection).Doc(checkpoint.ID), checkpoint)
+}
+
+// Backfill imports every PvP game the character played between from and to into aggregates.
+// Games are not tied to a session, so their snapshot links have no SessionID. Progress is checkpointed after
+// every page as the oldest activity handled, and a checkpoint for the same range is resumed from the one after it.
+func Backfill(
+	ctx context.Context,
+	db *firestore.Client,
+	w Writer,
+	cli *bungie.ClientWithResponses,
+	cache PGCRCache,
+	user User,
+	characterID string,
+	from, to time.Time,
+	pageSize int64,
+) (*BackfillCheckpoint, error) {
+	ctx = withLogFields(ctx, map[string]string{"userId": user.ID, "characterId": characterID})
+	l := zerolog.Ctx(ctx)
+
+	membershipType, membershipID, err := GetMembershipType(ctx, db, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch membership type: %w", err)
+	}
+
+	checkpoint, err := GetBackfillCheckpoint(ctx, db, user.ID, characterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch checkpoint: %w", err)
+	}
+	if checkpoint != nil && checkpoint.From.Equal(from) && checkpoint.To.Equal(to) {
+		if checkpoint.Completed {
+			l.Info().Msg("backfill already completed for this range")
+			return checkpoint, nil
+		}
+		l.Info().Str("lastInstanceId", checkpoint.LastInstanceID).Int64("processed", checkpoint.Processed).Msg("resuming backfill")
+	} else {
+		checkpoint = &BackfillCheckpoint{
+			ID:          backfillID(user.ID, characterID),
+			UserID:      user.ID,
+			CharacterID: characterID,
+			From:        from,
+			To:          to,
+		}
+	}
+
+	for page := int64(0); ; page++ {
+		pl := l.With().Int64("page", page).Logger()
+		histories, err := GetAllPVP(ctx, cli, db, membershipID, membershipType, characterID, pageSize, page)
+		if errors.Is(err, ErrNoActivities) {
+			pl.Info().Msg("reached the end of the activity history")
+			break
+		}
+		if err != nil {
+			return checkpoint, fmt.Errorf("failed to get activities for page %d: %w", page, err)
+		}
+
+		inRange := make([]ActivityHistory, 0, len(histories))
+		reachedStart := false
+		for _, history := range histories {
+			if history.Period.After(to) || checkpoint.handled(history) {
+				continue
+			}
+			if history.Period.Before(from) {
+				reachedStart = true
+				continue
+			}
+			inRange = append(inRange, history)
+		}
+
+		processed, skipped, err := backfillPage(ctx, db, w, cli, cache, user.ID, characterID, inRange)
+		if err != nil {
+			return checkpoint, err
+		}
+		checkpoint.Processed += processed
+		checkpoint.Skipped += skipped
+		for _, history := range inRange {
+			if checkpoint.LastPeriod == nil || history.Period.Before(*checkpoint.LastPeriod) {
+				checkpoint.LastPeriod = &history.Period
+				checkpoint.LastInstanceID = history.InstanceID
+			}
+		}
+		if reachedStart {
+			break
+		}
+		if len(inRange) == 0 {
+			// Pages before the checkpoint only need paging through
+			continue
+		}
+		if err := saveBackfillCheckpoint(ctx, db, w, checkpoint); err != nil {
+			return checkpoint, fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		pl.Info().
+			Int64("processed", checkpoint.Processed).
+			Int64("skipped", checkpoint.Skipped).
+			Msg("finished page")
+	}
+
+	checkpoint.Completed = true
+	if err := saveBackfillCheckpoint(ctx, db, w, checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	l.Info().
+		Int64("processed", checkpoint.Processed).
+		Int64("skipped", checkpoint.Skipped).
+		Msg("backfill completed")
+	return checkpoint, nil
+}
+
+// handled reports whether the activity was covered by an earlier page. Activities sharing the checkpoint's period are
+// only skipped when they are the checkpoint itself, the rest are left to the already linked check.
+func (c *BackfillCheckpoint) handled(history ActivityHistory) bool {
+	if c.LastPeriod == nil {
+		return false
+	}
+	if history.Period.Equal(*c.LastPeriod) {
+		return history.InstanceID == c.LastInstanceID
+	}
+	return history.Period.After(*c.LastPeriod)
+}
+
+// backfillPage links each history to an aggregate, skipping any the character is already linked to.
+func backfillPage(ctx context.Context, db *firestore.Client, w Writer, cli *bungie.ClientWithResponses, cache PGCRCache, userID, characterID string, histories []ActivityHistory) (int64, int64, error) {
+	if len(histories) == 0 {
+		return 0, 0, nil
+	}
+	IDs := make([]string, 0, len(histories))
+	for _, history := range histories {
+		IDs = append(IDs, history.InstanceID)
+	}
+	existing := make(map[string]*Aggregate)
+	// Firestore "in" queries accept at most 30 values
+	for i := 0; i < len(IDs); i += 30 {
+		end := min(i+30, len(IDs))
+		aggs, err := GetAggregatesByActivity(ctx, db, IDs[i:end])
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to fetch aggregates by the provided IDs: %w", err)
+		}
+		for _, agg := range aggs {
+			existing[agg.ActivityID] = &agg
+		}
+	}
+
+	var processed, skipped int64
+	for _, history := range histories {
+		ctx := withActivityLogger(ctx, history.InstanceID)
+		l := zerolog.Ctx(ctx)
+		if LookupLink(existing[history.InstanceID], characterID) != nil {
+			l.Debug().Msg("already linked to this activity")
+			skipped++
+			continue
+		}
+		performances, err := GetPerformances(ctx, cli, cache, db, history.InstanceID, characterID)
+		if err != nil {
+			l.Error().Err(err).Msg("failed to fetch performances")
+			skipped++
+			continue
+		}
+		performance, ok := performances[characterID]
+		if !ok {
+			l.Warn().Msg("no performance found for member")
+			skipped++
+			continue
+		}
+		if _, err := SetAggregate(ctx, db, w, userID, characterID, history, history.Period, performance, nil); err != nil {
+			return processed, skipped, fmt.Errorf("failed to add aggregate for %s: %w", history.InstanceID, err)
+		}
+		processed++
+	}
+	return processed, skipped, nil
+}
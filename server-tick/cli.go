@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"serverTick/bungie"
+	"serverTick/utils"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const cliUsage = `usage:
+  server-tick tick session <sessionId> [flags]
+  server-tick tick user <userId> [flags]
+  server-tick tick activity <instanceId> --character <characterId> [--session <sessionId>] [flags]
+  server-tick backfill <userId> --from <YYYY-MM-DD> [--to <YYYY-MM-DD>] [--character <characterId>] [--page-size <n>] [flags]
+  server-tick rollup <userId> [flags]
+  server-tick performance <userId> [flags]
+  server-tick export session <sessionId> [--format markdown|json|svg] [--output <file>] [--verbose]
+
+flags:
+  --dry-run      record firestore changes instead of applying them
+  --output       file to write the dry run change plan to, stdout when empty
+  --verbose      human readable debug logging and printed results
+  --skip-save    skip saving the current loadout as a snapshot
+  --pgcr-cache   post game carnage report cache: firestore, file or off
+  --pgcr-cache-dir  directory for the file cache`
+
+var errUsage = errors.New("invalid usage")
+
+// runCLI runs the tick logic on demand for a targeted session, user or activity instead of every pending session.
+func runCLI(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	switch args[0] {
+	case "tick":
+		return runTick(ctx, args[1], args[2:])
+	case "backfill":
+		return runBackfill(ctx, args[1:])
+	case "rollup":
+		return runRollup(ctx, args[1:])
+	case "performance":
+		return runPerformance(ctx, args[1:])
+	case "export":
+		return runExport(ctx, args[1], args[2:])
+	default:
+		return fmt.Errorf("unknown command %q: %w", args[0], errUsage)
+	}
+}
+
+type cliOptions struct {
+	dryRun       bool
+	output       string
+	verbose      bool
+	skipSave     bool
+	pgcrCache    string
+	pgcrCacheDir string
+}
+
+func registerCommonFlags(fs *flag.FlagSet) *cliOptions {
+	opts := &cliOptions{}
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "record firestore changes instead of applying them")
+	fs.StringVar(&opts.output, "output", "", "file to write the dry run change plan to")
+	fs.BoolVar(&opts.verbose, "verbose", false, "human readable debug logging and printed results")
+	fs.BoolVar(&opts.skipSave, "skip-save", false, "skip saving the current loadout as a snapshot")
+	fs.StringVar(&opts.pgcrCache, "pgcr-cache", defaultPGCRCache, "post game carnage report cache: firestore, file or off")
+	fs.StringVar(&opts.pgcrCacheDir, "pgcr-cache-dir", defaultPGCRCacheDir, "directory for the file cache")
+	return opts
+}
+
+// parseArgs parses flags that can appear before or after the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// cliEnv holds the clients every command needs.
+type cliEnv struct {
+	db     *firestore.Client
+	cli    *bungie.ClientWithResponses
+	w      Writer
+	cache  PGCRCache
+	hooks  *Webhooks
+	config Config
+	opts   *cliOptions
+}
+
+// newCLIEnv builds the clients for a command. Only commands that talk to Bungie need a destiny client, and with it
+// the D2_API_KEY; the rest only read and write firestore.
+func newCLIEnv(ctx context.Context, opts *cliOptions, withDestiny bool) (*cliEnv, error) {
+	if opts.verbose {
+		log.Logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).
+			Level(zerolog.DebugLevel).
+			With().
+			Timestamp().
+			Logger()
+	}
+	config := Config{
+		DestinyAPIKey: os.Getenv("D2_API_KEY"),
+		SkipSave:      opts.skipSave,
+		DryRun:        opts.dryRun,
+		DryRunOutput:  opts.output,
+		PGCRCache:     opts.pgcrCache,
+		PGCRCacheDir:  opts.pgcrCacheDir,
+	}
+	if withDestiny && config.DestinyAPIKey == "" {
+		return nil, fmt.Errorf("D2_API_KEY is required")
+	}
+
+	db, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	if err := LoadActiveCollections(ctx, db); err != nil {
+		return nil, err
+	}
+	var cli *bungie.ClientWithResponses
+	if withDestiny {
+		cli, err = newDestinyClient(config.DestinyAPIKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start destiny client: %w", err)
+		}
+	}
+
+	var w Writer = FirestoreWriter{}
+	if config.DryRun {
+		w = NewDryRunWriter()
+	}
+	cache, err := NewPGCRCache(config.PGCRCache, config.PGCRCacheDir, db, w)
+	if err != nil {
+		return nil, err
+	}
+	return &cliEnv{db: db, cli: cli, w: w, cache: cache, hooks: NewWebhooks(config.DryRun), config: config, opts: opts}, nil
+}
+
+// close writes out the change plan for dry runs and releases the firestore client.
+func (e *cliEnv) close() error {
+	defer e.db.Close()
+	if dryRun, ok := e.w.(*DryRunWriter); ok {
+		return dryRun.WritePlan(e.config.DryRunOutput)
+	}
+	return nil
+}
+
+func runTick(ctx context.Context, target string, args []string) error {
+	fs := flag.NewFlagSet("tick "+target, flag.ContinueOnError)
+	opts := registerCommonFlags(fs)
+	characterID := fs.String("character", "", "character the activity should be linked to")
+	sessionID := fs.String("session", "", "optional session the activity should be added to")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+	ID := positional[0]
+	switch target {
+	case "session", "user":
+	case "activity":
+		if *characterID == "" {
+			return fmt.Errorf("--character is required: %w", errUsage)
+		}
+	default:
+		return fmt.Errorf("unknown tick target %q: %w", target, errUsage)
+	}
+
+	env, err := newCLIEnv(ctx, opts, true)
+	if err != nil {
+		return err
+	}
+	ctx = log.Logger.WithContext(ctx)
+
+	switch target {
+	case "session":
+		err = tickSession(ctx, env, ID)
+	case "user":
+		err = tickUser(ctx, env, ID)
+	case "activity":
+		var session *string
+		if *sessionID != "" {
+			session = sessionID
+		}
+		err = tickActivity(ctx, env, ID, *characterID, session)
+	}
+	if err == nil {
+		env.hooks.DeliverPending(ctx, env.db, env.w)
+	}
+	return errors.Join(err, env.close())
+}
+
+func tickSession(ctx context.Context, env *cliEnv, sessionID string) error {
+	session, err := GetSession(ctx, env.db, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.Status == nil || *session.Status != SessionPending {
+		zerolog.Ctx(ctx).Warn().Str("sessionId", sessionID).Msg("session is not pending, processing anyway")
+	}
+	if _, err := ProcessSession(ctx, env.db, env.w, env.cli, env.cache, env.hooks, env.config, *session); err != nil {
+		return err
+	}
+	if dryRun, ok := env.w.(*DryRunWriter); ok && env.opts.verbose {
+		// Nothing was written, so the stored session is unchanged. The plan goes to stdout on close when there's no
+		// output file
+		if env.config.DryRunOutput != "" {
+			utils.PrettyPrint(dryRun.Plan())
+		}
+		return nil
+	}
+	if env.opts.verbose {
+		updated, err := GetSession(ctx, env.db, sessionID)
+		if err != nil {
+			return err
+		}
+		utils.PrettyPrint(updated)
+	}
+	return nil
+}
+
+func tickUser(ctx context.Context, env *cliEnv, userID string) error {
+	user, err := GetUser(ctx, env.db, userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	sessions, err := GetUserSessions(ctx, env.db, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch sessions: %w", err)
+	}
+	l := zerolog.Ctx(ctx).With().Str("userId", user.ID).Logger()
+	if len(sessions) == 0 {
+		l.Info().Msg("no pending sessions for user")
+		return nil
+	}
+	l.Info().Int("sessions", len(sessions)).Msg("received sessions to process")
+
+	var errs []error
+	for _, session := range sessions {
+		if _, err := ProcessSession(ctx, env.db, env.w, env.cli, env.cache, env.hooks, env.config, session); err != nil {
+			errs = append(errs, fmt.Errorf("session %s: %w", session.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func tickActivity(ctx context.Context, env *cliEnv, instanceID, characterID string, sessionID *string) error {
+	ctx = withActivityLogger(ctx, instanceID)
+	user, err := GetUserByCharacter(ctx, env.db, characterID)
+	if err != nil {
+		return fmt.Errorf("failed to find user for character %s: %w", characterID, err)
+	}
+	ctx = withLogFields(ctx, map[string]string{"userId": user.ID, "characterId": characterID})
+
+	report, err := GetPostGameCarnageReport(ctx, env.cli, env.cache, instanceID)
+	if err != nil {
+		return err
+	}
+	histories, err := HistoriesFromPeriods(ctx, env.db, []bungie.StatsPeriodGroup{
+		{ActivityDetails: report.ActivityDetails, Period: report.Period},
+	})
+	if err != nil {
+		return err
+	}
+	if len(histories) == 0 {
+		return fmt.Errorf("failed to resolve activity definitions for %s", instanceID)
+	}
+	history := histories[0]
+
+	performance, ok := PerformancesFromReport(ctx, env.db, report, characterID)[characterID]
+	if !ok {
+		return fmt.Errorf("no performance found for character %s", characterID)
+	}
+	agg, err := SetAggregate(ctx, env.db, env.w, user.ID, characterID, history, history.Period, performance, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to add data to aggregate: %w", err)
+	}
+	if sessionID != nil {
+		if err := AddAggregateIDs(ctx, env.db, env.w, *sessionID, []string{agg.ID}); err != nil {
+			return fmt.Errorf("failed to add aggregate to session: %w", err)
+		}
+	}
+	zerolog.Ctx(ctx).Info().Str("aggregateId", agg.ID).Msg("linked activity")
+	if env.opts.verbose {
+		utils.PrettyPrint(agg)
+	}
+	return nil
+}
+
+func runBackfill(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	opts := registerCommonFlags(fs)
+	fromFlag := fs.String("from", "", "first day to import, YYYY-MM-DD")
+	toFlag := fs.String("to", "", "last day to import, YYYY-MM-DD. Defaults to now")
+	characterID := fs.String("character", "", "only import this character instead of all of the user's characters")
+	pageSize := fs.Int64("page-size", MaxActivityPageSize, "activities requested per page of history")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *fromFlag == "" {
+		return errUsage
+	}
+	if *pageSize <= 0 || *pageSize > MaxActivityPageSize {
+		return fmt.Errorf("--page-size must be between 1 and %d: %w", MaxActivityPageSize, errUsage)
+	}
+	from, err := time.Parse(time.DateOnly, *fromFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --from: %w", err)
+	}
+	to := time.Now().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if *toFlag != "" {
+		to, err = time.Parse(time.DateOnly, *toFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --to: %w", err)
+		}
+		// Include the whole last day
+		to = to.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	env, err := newCLIEnv(ctx, opts, true)
+	if err != nil {
+		return err
+	}
+	ctx = log.Logger.WithContext(ctx)
+
+	err = backfillUser(ctx, env, positional[0], *characterID, from, to, *pageSize)
+	return errors.Join(err, env.close())
+}
+
+func backfillUser(ctx context.Context, env *cliEnv, userID, characterID string, from, to time.Time, pageSize int64) error {
+	user, err := GetUser(ctx, env.db, userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	characterIDs := user.CharacterIDs
+	if characterID != "" {
+		characterIDs = []string{characterID}
+	}
+
+	var errs []error
+	for _, ID := range characterIDs {
+		checkpoint, err := Backfill(ctx, env.db, env.w, env.cli, env.cache, *user, ID, from, to, pageSize)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("character %s: %w", ID, err))
+		}
+		if env.opts.verbose && checkpoint != nil {
+			utils.PrettyPrint(checkpoint)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runRollup(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rollup", flag.ContinueOnError)
+	opts := registerCommonFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	env, err := newCLIEnv(ctx, opts, false)
+	if err != nil {
+		return err
+	}
+	ctx = log.Logger.WithContext(ctx)
+
+	err = rollupUser(ctx, env, positional[0])
+	return errors.Join(err, env.close())
+}
+
+func rollupUser(ctx context.Context, env *cliEnv, userID string) error {
+	user, err := GetUser(ctx, env.db, userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	now := time.Now()
+	windows, err := rollupWindows(ctx, env.db, now)
+	if err != nil {
+		return fmt.Errorf("failed to resolve rollup windows: %w", err)
+	}
+	return RollupUser(ctx, env.db, env.w, *user, windows, now)
+}
+
+// runPerformance rebuilds the performance of every snapshot of a user from the games linked to them.
+func runPerformance(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("performance", flag.ContinueOnError)
+	opts := registerCommonFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	env, err := newCLIEnv(ctx, opts, false)
+	if err != nil {
+		return err
+	}
+	ctx = log.Logger.WithContext(ctx)
+
+	err = rebuildPerformance(ctx, env, positional[0])
+	return errors.Join(err, env.close())
+}
+
+func rebuildPerformance(ctx context.Context, env *cliEnv, userID string) error {
+	user, err := GetUser(ctx, env.db, userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	count, err := RebuildSnapshotPerformance(ctx, env.db, env.w, *user)
+	if err != nil {
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Str("userId", userID).Int("snapshots", count).Msg("rebuilt snapshot performance")
+	return nil
+}
+
+func runExport(ctx context.Context, target string, args []string) error {
+	if target != "session" {
+		return fmt.Errorf("unknown export target %q: %w", target, errUsage)
+	}
+	fs := flag.NewFlagSet("export "+target, flag.ContinueOnError)
+	format := fs.String("format", ExportMarkdown, "export format: markdown, json or svg")
+	output := fs.String("output", "", "file to write the export to, stdout when empty")
+	verbose := fs.Bool("verbose", false, "human readable debug logging")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+	switch *format {
+	case ExportMarkdown, ExportJSON, ExportSVG:
+	default:
+		return fmt.Errorf("unknown format %q: %w", *format, errUsage)
+	}
+
+	// Exports only read, so the post game carnage report cache is never needed
+	env, err := newCLIEnv(ctx, &cliOptions{verbose: *verbose, pgcrCache: "off"}, false)
+	if err != nil {
+		return err
+	}
+	ctx = log.Logger.WithContext(ctx)
+
+	err = exportSession(ctx, env, positional[0], *format, *output)
+	return errors.Join(err, env.close())
+}
+
+func exportSession(ctx context.Context, env *cliEnv, sessionID, format, output string) error {
+	export, err := BuildSessionExport(ctx, env.db, sessionID)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		return WriteSessionExport(os.Stdout, export, format)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	if err := WriteSessionExport(f, export, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
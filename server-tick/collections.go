@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+)
+
+// activeCollections maps a manifest collection to the slot currently serving it. The migration job writes each new
+// manifest version into an inactive slot and switches the pointer in the destiny configuration once it is complete.
+var activeCollections = map[ManifestCollection]string{}
+
+// ActiveCollection is the pointer the migration job keeps for each manifest collection.
+type ActiveCollection struct {
+	Collection string `firestore:"collection" json:"collection"`
+	Version    string `firestore:"version" json:"version"`
+}
+
+// Name returns the Firestore collection currently serving the definitions. Collections without a pointer are
+// served from the collection itself.
+func (c ManifestCollection) Name() string {
+	if name, ok := activeCollections[c]; ok && name != "" {
+		return name
+	}
+	return string(c)
+}
+
+// LoadActiveCollections reads which slot every manifest collection is served from. Called once at startup so a
+// run reads a consistent version even if a migration switches slots part way through.
+func LoadActiveCollections(ctx context.Context, db *firestore.Client) error {
+	doc, err := db.Collection(ConfigurationCollection).Doc(DestinyDocument).Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get destiny configuration: %w", err)
+	}
+	config := struct {
+		ActiveCollections map[string]ActiveCollection `firestore:"activeCollections"`
+	}{}
+	if err := doc.DataTo(&config); err != nil {
+		return fmt.Errorf("failed to read destiny configuration: %w", err)
+	}
+	for base, active := range config.ActiveCollections {
+		activeCollections[ManifestCollection(base)] = active.Collection
+	}
+	zerolog.Ctx(ctx).Debug().Any("activeCollections", activeCollections).Msg("loaded active manifest collections")
+	return nil
+}
@@ -1,20 +1,24 @@
 package main
 
 import (
-	"log/slog"
+	"context"
 	"serverTick/bungie"
 	"strconv"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 func TransformItemToDetails(
+	ctx context.Context,
 	item *bungie.DestinyItem,
 	items map[string]ItemDefinition,
 	damages map[string]DamageType,
 	perks map[string]PerkDefinition,
 	stats map[string]StatDefinition,
+	plugSets map[string]PlugSetDefinition,
+	seasons map[string]SeasonDefinition,
 	styleItem *ItemDefinition,
+	locale string,
 ) *ItemProperties {
 	if item == nil {
 		return nil
@@ -23,33 +27,34 @@ func TransformItemToDetails(
 
 	// Generate Base Info
 	if item.Item != nil {
-		result.BaseInfo = generateBaseInfo(item, items, damages, styleItem)
+		result.BaseInfo = generateBaseInfo(item, items, damages, seasons, styleItem, locale)
+		result.PerkColumns = generatePerkColumns(ctx, item, items, plugSets, locale)
 	}
 
 	// Generate Perks
 	if item.Perks != nil && item.Perks.Data != nil {
-		result.Perks = generatePerks(item, perks)
+		result.Perks = generatePerks(ctx, item, perks, locale)
 	}
 
 	// Generate Sockets
 	if item.Sockets != nil && item.Sockets.Data != nil {
-		result.Sockets = generateSockets(item, items)
+		result.Sockets = generateSockets(ctx, item, items, locale)
 	}
 
 	// Generate Stats
 	if item.Stats != nil && item.Stats.Data != nil {
-		result.Stats = generateStats(item, stats)
+		result.Stats = generateStats(ctx, item, stats, locale)
 	}
 
 	return &result
 }
 
-func generateBaseInfo(item *bungie.DestinyItem, items map[string]ItemDefinition, damages map[string]DamageType, styleItem *ItemDefinition) BaseItemInfo {
+func generateBaseInfo(item *bungie.DestinyItem, items map[string]ItemDefinition, damages map[string]DamageType, seasons map[string]SeasonDefinition, styleItem *ItemDefinition, locale string) BaseItemInfo {
 	c := *item.Item.ItemComponent
 	hash := strconv.Itoa(int(*c.ItemHash))
-	name := items[hash].DisplayProperties.Name
-	icon := items[hash].DisplayProperties.Icon
 	it := items[hash]
+	name, _ := localizedDisplay(locale, it.Locales, it.DisplayProperties.Name, it.DisplayProperties.Description)
+	icon := it.DisplayProperties.Icon
 
 	base := BaseItemInfo{
 		BucketHash:                 int64(*c.BucketHash),
@@ -62,13 +67,21 @@ func generateBaseInfo(item *bungie.DestinyItem, items map[string]ItemDefinition,
 		TierTypeName:               it.Inventory.TierTypeName,
 		TierType:                   it.Inventory.TierType,
 	}
+	if season, ok := seasons[strconv.FormatInt(it.SeasonHash, 10)]; ok {
+		base.Season = &ItemSeason{
+			Hash:   season.Hash,
+			Name:   season.DisplayProperties.Name,
+			Number: season.SeasonNumber,
+		}
+	}
 
 	if styleItem != nil {
+		styleName, _ := localizedDisplay(locale, styleItem.Locales, styleItem.DisplayProperties.Name, styleItem.DisplayProperties.Description)
 		base.StyleBasicInfo = &BaseItemInfo{
 			BucketHash:                 int64(*c.BucketHash),
 			InstanceId:                 *c.ItemInstanceId,
 			ItemHash:                   styleItem.Hash,
-			Name:                       styleItem.DisplayProperties.Name,
+			Name:                       styleName,
 			Icon:                       setBaseBungieURL(&styleItem.DisplayProperties.Icon),
 			ItemTypeAndTierDisplayName: styleItem.ItemTypeAndTierDisplayName,
 			ItemTypeDisplayName:        styleItem.ItemTypeDisplayName,
@@ -82,6 +95,7 @@ func generateBaseInfo(item *bungie.DestinyItem, items map[string]ItemDefinition,
 			hash := strconv.Itoa(int(*instance.DamageTypeHash))
 			def := damages[hash]
 			dc := def.Color
+			damageName, _ := localizedDisplay(locale, def.Locales, def.DisplayProperties.Name, def.DisplayProperties.Description)
 
 			base.Damage = &DamageInfo{
 				Color: Color{
@@ -91,7 +105,7 @@ func generateBaseInfo(item *bungie.DestinyItem, items map[string]ItemDefinition,
 					Red:   dc.Red,
 				},
 				DamageIcon:      def.DisplayProperties.Icon,
-				DamageType:      def.DisplayProperties.Name,
+				DamageType:      damageName,
 				TransparentIcon: def.TransparentIconPath,
 			}
 		}
@@ -99,47 +113,131 @@ func generateBaseInfo(item *bungie.DestinyItem, items map[string]ItemDefinition,
 	return base
 }
 
-func generatePerks(item *bungie.DestinyItem, perks map[string]PerkDefinition) []Perk {
+func generatePerks(ctx context.Context, item *bungie.DestinyItem, perks map[string]PerkDefinition, locale string) []Perk {
+	l := zerolog.Ctx(ctx)
 	var results []Perk
 	for _, p := range *item.Perks.Data.Perks {
 		perk, ok := perks[strconv.Itoa(int(*p.PerkHash))]
 		if !ok {
-			log.Warn().Uint32("perkHash", *p.PerkHash).Msg("Perk not found in manifest")
+			l.Warn().Uint32("perkHash", *p.PerkHash).Msg("Perk not found in manifest")
 			continue
 		}
 		if !perk.IsDisplayable {
 			continue
 		}
+		name, description := localizedDisplay(locale, perk.Locales, perk.DisplayProperties.Name, perk.DisplayProperties.Description)
 		results = append(results, Perk{
 			Hash:        int64(*p.PerkHash),
 			IconPath:    Of(setBaseBungieURL(p.IconPath)),
-			Name:        perk.DisplayProperties.Name,
-			Description: &perk.DisplayProperties.Description,
+			Name:        name,
+			Description: &description,
 		})
 	}
 	return results
 }
 
-func generateSockets(item *bungie.DestinyItem, items map[string]ItemDefinition) *[]Socket {
+// perkPlugSetHash is the plug set a socket rolls from, the randomized one for weapons with random rolls.
+func perkPlugSetHash(entry SocketEntry) int64 {
+	if entry.RandomizedPlugSetHash != 0 {
+		return entry.RandomizedPlugSetHash
+	}
+	return entry.ReusablePlugSetHash
+}
+
+// weaponPerkSockets returns the indexes of the item's sockets in the weapon perks category.
+func weaponPerkSockets(def ItemDefinition) []int {
+	if def.Sockets == nil {
+		return nil
+	}
+	var indexes []int
+	for _, category := range def.Sockets.SocketCategories {
+		if category.SocketCategoryHash != WeaponPerksSocketCategory {
+			continue
+		}
+		for _, index := range category.SocketIndexes {
+			if index >= 0 && index < len(def.Sockets.SocketEntries) {
+				indexes = append(indexes, index)
+			}
+		}
+	}
+	return indexes
+}
+
+// generatePerkColumns lists every perk that can roll in each of the weapon's perk sockets, along with the one the
+// instance has equipped. Items without weapon perk sockets, such as armor, have none.
+func generatePerkColumns(ctx context.Context, item *bungie.DestinyItem, items map[string]ItemDefinition, plugSets map[string]PlugSetDefinition, locale string) []PerkColumn {
+	l := zerolog.Ctx(ctx)
+	c := *item.Item.ItemComponent
+	def, ok := items[strconv.Itoa(int(*c.ItemHash))]
+	if !ok {
+		return nil
+	}
+	equipped := make(map[int]int64)
+	if item.Sockets != nil && item.Sockets.Data != nil && item.Sockets.Data.Sockets != nil {
+		for i, s := range *item.Sockets.Data.Sockets {
+			if s.PlugHash != nil {
+				equipped[i] = int64(*s.PlugHash)
+			}
+		}
+	}
+
+	var columns []PerkColumn
+	for _, index := range weaponPerkSockets(def) {
+		hash := perkPlugSetHash(def.Sockets.SocketEntries[index])
+		plugSet, ok := plugSets[strconv.FormatInt(hash, 10)]
+		if !ok {
+			continue
+		}
+		column := PerkColumn{SocketIndex: index, Perks: make([]PerkOption, 0, len(plugSet.ReusablePlugItems))}
+		if plugHash, ok := equipped[index]; ok {
+			column.EquippedHash = &plugHash
+		}
+		seen := make(map[int64]bool)
+		for _, plug := range plugSet.ReusablePlugItems {
+			if seen[plug.PlugItemHash] {
+				continue
+			}
+			seen[plug.PlugItemHash] = true
+			perk, ok := items[strconv.FormatInt(plug.PlugItemHash, 10)]
+			if !ok {
+				l.Warn().Int64("plugItemHash", plug.PlugItemHash).Msg("Plug not found in manifest")
+				continue
+			}
+			name, _ := localizedDisplay(locale, perk.Locales, perk.DisplayProperties.Name, perk.DisplayProperties.Description)
+			column.Perks = append(column.Perks, PerkOption{
+				Hash:     plug.PlugItemHash,
+				Name:     name,
+				IconPath: Of(setBaseBungieURL(&perk.DisplayProperties.Icon)),
+				CanRoll:  plug.CurrentlyCanRoll,
+			})
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+func generateSockets(ctx context.Context, item *bungie.DestinyItem, items map[string]ItemDefinition, locale string) *[]Socket {
+	l := zerolog.Ctx(ctx)
 	var sockets []Socket
 	for _, s := range *item.Sockets.Data.Sockets {
 		if s.PlugHash == nil {
-			log.Warn().Msg("Socket has no plug hash")
+			l.Warn().Msg("Socket has no plug hash")
 			continue
 		}
 		socket, ok := items[strconv.Itoa(int(*s.PlugHash))]
 		if !ok {
-			log.Warn().Uint32("socketHash", *s.PlugHash).Msg("Socket not found in manifest")
+			l.Warn().Uint32("socketHash", *s.PlugHash).Msg("Socket not found in manifest")
 			continue
 		}
 
 		hash := int(*s.PlugHash)
+		name, description := localizedDisplay(locale, socket.Locales, socket.DisplayProperties.Name, socket.DisplayProperties.Description)
 		sockets = append(sockets, Socket{
 			IsEnabled:                 s.IsEnabled,
 			IsVisible:                 s.IsVisible,
 			PlugHash:                  hash,
-			Name:                      socket.DisplayProperties.Name,
-			Description:               socket.DisplayProperties.Description,
+			Name:                      name,
+			Description:               description,
 			ItemTypeDisplayName:       Of(socket.ItemTypeDisplayName),
 			ItemTypeTieredDisplayName: Of(socket.ItemTypeAndTierDisplayName),
 			Icon:                      Of(setBaseBungieURL(&socket.DisplayProperties.Icon)),
@@ -148,37 +246,39 @@ func generateSockets(item *bungie.DestinyItem, items map[string]ItemDefinition)
 	return &sockets
 }
 
-func generateStats(item *bungie.DestinyItem, statDefinitions map[string]StatDefinition) Stats {
+func generateStats(ctx context.Context, item *bungie.DestinyItem, statDefinitions map[string]StatDefinition, locale string) Stats {
+	l := zerolog.Ctx(ctx)
 	stats := make(Stats)
 	for key, s := range *item.Stats.Data.Stats {
 		if s.StatHash == nil || s.Value == nil {
-			slog.Warn("Missing stat hash or value for stat: ", key)
+			l.Warn().Str("statKey", key).Msg("Missing stat hash or value for stat")
 			continue
 		}
 		stat, ok := statDefinitions[strconv.Itoa(int(*s.StatHash))]
 		if !ok {
-			slog.Warn("Stat not found in manifest: ", strconv.Itoa(int(*s.StatHash)))
+			l.Warn().Uint32("statHash", *s.StatHash).Msg("Stat not found in manifest")
 			continue
 		}
 		value := int64(*s.Value)
+		name, description := localizedDisplay(locale, stat.Locales, stat.DisplayProperties.Name, stat.DisplayProperties.Description)
 		stats[key] = GunStat{
-			Description: stat.DisplayProperties.Description,
+			Description: description,
 			Hash:        stat.Hash,
-			Name:        stat.DisplayProperties.Name,
+			Name:        name,
 			Value:       value,
 		}
 	}
 	return stats
 }
 
-func TransformD2HistoricalStatValues(stats *map[string]bungie.HistoricalStatsValue) *map[string]UniqueStatValue {
+func TransformD2HistoricalStatValues(ctx context.Context, stats *map[string]bungie.HistoricalStatsValue) *map[string]UniqueStatValue {
 	if stats == nil {
 		return nil
 	}
 
 	result := make(map[string]UniqueStatValue)
 	for key, value := range *stats {
-		values := transformD2StatValue(&value)
+		values := transformD2StatValue(ctx, &value)
 		if values == nil {
 			continue
 		}
@@ -188,12 +288,12 @@ func TransformD2HistoricalStatValues(stats *map[string]bungie.HistoricalStatsVal
 	return &result
 }
 
-func transformD2StatValue(item *bungie.HistoricalStatsValue) *UniqueStatValue {
+func transformD2StatValue(ctx context.Context, item *bungie.HistoricalStatsValue) *UniqueStatValue {
 	if item == nil {
 		return nil
 	}
 	if item.Basic == nil {
-		slog.Warn("Missing basic value for stat")
+		zerolog.Ctx(ctx).Warn().Msg("Missing basic value for stat")
 		return nil
 	}
 	result := &UniqueStatValue{
@@ -227,11 +327,11 @@ func uintToInt64[T ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64](item *T) *int64
 	return Of(int64(*item))
 }
 
-func TransformHistoricActivity(history *bungie.HistoricalStatsActivity, activityDefinition, directorDef ActivityDefinition, modeDefinition ActivityModeDefinition) *ActivityHistory {
+func TransformHistoricActivity(ctx context.Context, history *bungie.HistoricalStatsActivity, activityDefinition, directorDef ActivityDefinition, modeDefinition ActivityModeDefinition) *ActivityHistory {
 	if history == nil {
 		return nil
 	}
-	mode := ActivityModeTypeToString((*bungie.CurrentActivityModeType)(history.Mode))
+	mode := ActivityModeTypeToString(ctx, (*bungie.CurrentActivityModeType)(history.Mode))
 	return &ActivityHistory{
 		ActivityHash: *uintToInt64(history.DirectorActivityHash),
 		InstanceID:   *history.InstanceId,
@@ -246,15 +346,15 @@ func TransformHistoricActivity(history *bungie.HistoricalStatsActivity, activity
 	}
 }
 
-func TransformPeriodGroups(period []bungie.StatsPeriodGroup, activities map[string]ActivityDefinition, directorDefinitions map[string]ActivityDefinition, modes map[string]ActivityModeDefinition) []ActivityHistory {
+func TransformPeriodGroups(ctx context.Context, period []bungie.StatsPeriodGroup, activities map[string]ActivityDefinition, directorDefinitions map[string]ActivityDefinition, modes map[string]ActivityModeDefinition, mapKeys map[string]CrucibleMapIndexEntry) []ActivityHistory {
 	if period == nil {
 		return nil
 	}
 	var result []ActivityHistory
 	for _, group := range period {
-		r := TransformPeriodGroup(&group, activities, directorDefinitions, modes)
+		r := TransformPeriodGroup(ctx, &group, activities, directorDefinitions, modes, mapKeys)
 		if r == nil {
-			log.Warn().Msg("period group returned nil")
+			zerolog.Ctx(ctx).Warn().Msg("period group returned nil")
 			continue
 		}
 		result = append(result, *r)
@@ -262,23 +362,24 @@ func TransformPeriodGroups(period []bungie.StatsPeriodGroup, activities map[stri
 	return result
 }
 
-func TransformPeriodGroup(period *bungie.StatsPeriodGroup, activities map[string]ActivityDefinition, directorDefintions map[string]ActivityDefinition, modes map[string]ActivityModeDefinition) *ActivityHistory {
+func TransformPeriodGroup(ctx context.Context, period *bungie.StatsPeriodGroup, activities map[string]ActivityDefinition, directorDefintions map[string]ActivityDefinition, modes map[string]ActivityModeDefinition, mapKeys map[string]CrucibleMapIndexEntry) *ActivityHistory {
 	if period == nil {
 		return nil
 	}
 
+	l := zerolog.Ctx(ctx).With().Str("activityId", *period.ActivityDetails.InstanceId).Logger()
 	definition, ok := activities[strconv.Itoa(int(*period.ActivityDetails.ReferenceId))]
 	if !ok {
-		log.Warn().Msgf("Activity locale not found in manifest: %d ", period.ActivityDetails.ReferenceId)
+		l.Warn().Uint32("referenceId", *period.ActivityDetails.ReferenceId).Msg("Activity locale not found in manifest")
 		return nil
 	}
 	directorDefinition, ok := directorDefintions[strconv.Itoa(int(*period.ActivityDetails.DirectorActivityHash))]
 	if !ok {
-		log.Warn().Msgf("Activity Directory not found in manifest: %d", period.ActivityDetails.DirectorActivityHash)
+		l.Warn().Uint32("directorActivityHash", *period.ActivityDetails.DirectorActivityHash).Msg("Activity Directory not found in manifest")
 		return nil
 	}
 	activityMode := modes[strconv.Itoa(directorDefinition.DirectActivityModeHash)]
-	mode := ActivityModeTypeToString((*bungie.CurrentActivityModeType)(period.ActivityDetails.Mode))
+	mode := ActivityModeTypeToString(ctx, (*bungie.CurrentActivityModeType)(period.ActivityDetails.Mode))
 	return &ActivityHistory{
 		ActivityHash: *uintToInt64(period.ActivityDetails.DirectorActivityHash),
 		InstanceID:   *period.ActivityDetails.InstanceId,
@@ -286,6 +387,7 @@ func TransformPeriodGroup(period *bungie.StatsPeriodGroup, activities map[string
 		Mode:         &mode,
 		ReferenceID:  *uintToInt64(period.ActivityDetails.ReferenceId),
 		Location:     definition.DisplayProperties.Name,
+		MapKey:       mapKeys[strconv.Itoa(int(*period.ActivityDetails.ReferenceId))].Key,
 		Description:  definition.DisplayProperties.Description,
 		Activity:     directorDefinition.DisplayProperties.Name,
 		ImageURL:     setBaseBungieURL(&definition.PgcrImage),
@@ -384,9 +486,9 @@ func WeaponsToInstanceWeapons(values *[]bungie.HistoricalWeaponStats, items map[
 	return result
 }
 
-func ActivityModeTypeToString(modeType *bungie.CurrentActivityModeType) string {
+func ActivityModeTypeToString(ctx context.Context, modeType *bungie.CurrentActivityModeType) string {
 	if modeType == nil {
-		slog.Warn("Activity Mode type is nil")
+		zerolog.Ctx(ctx).Warn().Msg("Activity Mode type is nil")
 		return "Unknown"
 	}
 	switch *modeType {
@@ -447,7 +549,7 @@ func ActivityModeTypeToString(modeType *bungie.CurrentActivityModeType) string {
 	}
 }
 
-func generateClassStats(statDefinitions map[string]StatDefinition, stats map[string]int32) map[string]ClassStat {
+func generateClassStats(ctx context.Context, statDefinitions map[string]StatDefinition, stats map[string]int32) map[string]ClassStat {
 	if statDefinitions == nil {
 		return nil
 	}
@@ -455,7 +557,7 @@ func generateClassStats(statDefinitions map[string]StatDefinition, stats map[str
 	for key, value := range stats {
 		info, ok := statDefinitions[key]
 		if !ok {
-			slog.Warn("Missing stat", "statKey", key)
+			zerolog.Ctx(ctx).Warn().Str("statKey", key).Msg("Missing stat")
 			continue
 		}
 		i := ClassStat{
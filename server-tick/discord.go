@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	discordUsername     = "oneTrick"
+	discordWinColor     = 0x3b8a57
+	discordLossColor    = 0x8a3b3b
+	discordNeutralColor = 0x1b1d26
+	// discordTopWeapons is how many weapons get their own embed under a match, Discord allows 10 embeds per message
+	discordTopWeapons = 3
+)
+
+// DiscordMessage is the body of a Discord webhook execution.
+type DiscordMessage struct {
+	Username string         `json:"username"`
+	Embeds   []DiscordEmbed `json:"embeds"`
+}
+
+type DiscordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color,omitempty"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+	Author      *DiscordAuthor `json:"author,omitempty"`
+	Image       *DiscordImage  `json:"image,omitempty"`
+	Fields      []DiscordField `json:"fields,omitempty"`
+	Footer      *DiscordFooter `json:"footer,omitempty"`
+}
+
+type DiscordAuthor struct {
+	Name    string `json:"name"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type DiscordImage struct {
+	URL string `json:"url"`
+}
+
+type DiscordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type DiscordFooter struct {
+	Text string `json:"text"`
+}
+
+// DiscordMessageFor formats the event as Discord embeds. Events we don't know how to format get a plain embed with
+// their type so the channel still hears about them.
+func DiscordMessageFor(event WebhookEvent) DiscordMessage {
+	embeds := make([]DiscordEmbed, 0)
+	switch data := event.Data.(type) {
+	case SessionStartedData:
+		embeds = append(embeds, DiscordEmbed{
+			Title:       "Session started",
+			Description: stringOr(data.Name, ""),
+			Color:       discordNeutralColor,
+		})
+	case MatchRecordedData:
+		embeds = append(embeds, discordMatchEmbeds(data)...)
+	case SnapshotCreatedData:
+		embeds = append(embeds, DiscordEmbed{
+			Title:       "New build detected",
+			Description: data.Name,
+			Color:       discordNeutralColor,
+		})
+	case SessionEndedData:
+		embeds = append(embeds, discordSessionEndedEmbed(data))
+	default:
+		embeds = append(embeds, DiscordEmbed{Title: string(event.Type), Color: discordNeutralColor})
+	}
+	embeds[0].Timestamp = event.CreatedAt.UTC().Format(time.RFC3339)
+	return DiscordMessage{Username: discordUsername, Embeds: embeds}
+}
+
+// discordMatchEmbeds is the match itself followed by one small embed per top weapon, since an embed only has room
+// for a single large image and author icons are the only way to show several.
+func discordMatchEmbeds(data MatchRecordedData) []DiscordEmbed {
+	color := discordLossColor
+	if data.Win {
+		color = discordWinColor
+	}
+	title := data.Standing
+	if data.Location != "" {
+		title = fmt.Sprintf("%s on %s", data.Standing, data.Location)
+	}
+	match := DiscordEmbed{
+		Title:       title,
+		Description: data.Mode,
+		Color:       color,
+		Fields: []DiscordField{
+			{Name: "K / D / A", Value: fmt.Sprintf("%.0f / %.0f / %.0f", data.Kills, data.Deaths, data.Assists), Inline: true},
+			{Name: "KDA", Value: formatRatio(data.KDA), Inline: true},
+		},
+	}
+	if data.ImageURL != "" {
+		match.Image = &DiscordImage{URL: data.ImageURL}
+	}
+	if data.SnapshotName != "" {
+		match.Fields = append(match.Fields, DiscordField{Name: "Build", Value: data.SnapshotName, Inline: true})
+	}
+
+	embeds := []DiscordEmbed{match}
+	for i, weapon := range data.Weapons {
+		if i == discordTopWeapons {
+			break
+		}
+		author := &DiscordAuthor{Name: fmt.Sprintf("%s · %d kills", weapon.Name, weapon.Kills)}
+		if weapon.Icon != nil {
+			author.IconURL = *weapon.Icon
+		}
+		embeds = append(embeds, DiscordEmbed{Author: author, Color: color})
+	}
+	return embeds
+}
+
+func discordSessionEndedEmbed(data SessionEndedData) DiscordEmbed {
+	embed := DiscordEmbed{
+		Title: "Session ended",
+		Color: discordNeutralColor,
+	}
+	if data.Reason != "" {
+		embed.Footer = &DiscordFooter{Text: "Ended: " + string(data.Reason)}
+	}
+	s := data.Summary
+	if s == nil {
+		return embed
+	}
+	if s.Wins > s.Losses {
+		embed.Color = discordWinColor
+	} else if s.Losses > s.Wins {
+		embed.Color = discordLossColor
+	}
+	embed.Fields = []DiscordField{
+		{Name: "Record", Value: fmt.Sprintf("%d-%d", s.Wins, s.Losses), Inline: true},
+		{Name: "K/D", Value: formatRatio(s.KD), Inline: true},
+		{Name: "KDA", Value: formatRatio(s.KDA), Inline: true},
+	}
+	if s.BestGame != nil {
+		embed.Fields = append(embed.Fields, DiscordField{
+			Name:  "Best game",
+			Value: fmt.Sprintf("%s, %.0f / %.0f / %.0f", s.BestGame.Location, s.BestGame.Kills, s.BestGame.Deaths, s.BestGame.Assists),
+		})
+	}
+	if len(s.Weapons) > 0 {
+		names := make([]string, 0, discordTopWeapons)
+		for i, weapon := range s.Weapons {
+			if i == discordTopWeapons {
+				break
+			}
+			names = append(names, fmt.Sprintf("%s (%d)", weapon.Name, weapon.Kills))
+		}
+		embed.Fields = append(embed.Fields, DiscordField{Name: "Top weapons", Value: strings.Join(names, "\n")})
+	}
+	return embed
+}
+
+func stringOr(value *string, fallback string) string {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// sessionExportVersion is bumped whenever a field of SessionExport changes meaning or is removed
+const sessionExportVersion = 1
+
+const (
+	ExportMarkdown = "markdown"
+	ExportJSON     = "json"
+	ExportSVG      = "svg"
+)
+
+// SessionExport is the JSON export of a session recap.
+type SessionExport struct {
+	Version     int            `json:"version"`
+	SessionID   string         `json:"sessionId"`
+	UserID      string         `json:"userId"`
+	CharacterID string         `json:"characterId"`
+	Name        string         `json:"name"`
+	StartedAt   time.Time      `json:"startedAt"`
+	CompletedAt *time.Time     `json:"completedAt,omitempty"`
+	Summary     SessionSummary `json:"summary"`
+}
+
+// BuildSessionExport gathers the recap of a session. Sessions that haven't ended have no stored summary yet, so
+// theirs is computed from the aggregates so far.
+func BuildSessionExport(ctx context.Context, db *firestore.Client, sessionID string) (*SessionExport, error) {
+	session, err := GetSession(ctx, db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	summary := session.Summary
+	if summary == nil {
+		summary, err = ComputeSessionSummary(ctx, db, *session)
+		if err != nil {
+			return nil, err
+		}
+	}
+	export := &SessionExport{
+		Version:     sessionExportVersion,
+		SessionID:   session.ID,
+		UserID:      session.UserID,
+		CharacterID: session.CharacterID,
+		Name:        "Session " + session.StartedAt.Format(time.DateOnly),
+		StartedAt:   session.StartedAt,
+		CompletedAt: session.CompletedAt,
+		Summary:     *summary,
+	}
+	if session.Name != nil && *session.Name != "" {
+		export.Name = *session.Name
+	}
+	return export, nil
+}
+
+// WriteSessionExport renders the export in the given format: markdown, json or svg.
+func WriteSessionExport(out io.Writer, export *SessionExport, format string) error {
+	switch format {
+	case ExportMarkdown:
+		return writeSessionMarkdown(out, export)
+	case ExportJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(export)
+	case ExportSVG:
+		return writeSessionSVG(out, export)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+func formatRatio(value float64) string {
+	return fmt.Sprintf("%.2f", value)
+}
+
+func formatDuration(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// markdownCell keeps a value from breaking out of its table cell.
+func markdownCell(value string) string {
+	return strings.ReplaceAll(value, "|", "\\|")
+}
+
+func writeSessionMarkdown(out io.Writer, export *SessionExport) error {
+	s := export.Summary
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", export.Name)
+	fmt.Fprintf(&b, "**%d-%d** · K/D %s · KDA %s · Efficiency %s · %s played\n\n",
+		s.Wins, s.Losses, formatRatio(s.KD), formatRatio(s.KDA), formatRatio(s.Efficiency), formatDuration(s.TimePlayed))
+	if s.Streak.Length > 0 {
+		fmt.Fprintf(&b, "Ended on a %d game %s streak, longest win streak %d\n\n", s.Streak.Length, s.Streak.Type, s.Streak.LongestWinRun)
+	}
+
+	b.WriteString("## Games\n\n")
+	b.WriteString("| Map | Mode | Result | K | D | A | KDA |\n")
+	b.WriteString("|---|---|---|---|---|---|---|\n")
+	for _, game := range s.Matches {
+		result := "Loss"
+		if game.Win {
+			result = "Win"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %.0f | %.0f | %.0f | %s |\n",
+			markdownCell(game.Location), markdownCell(game.Mode), result, game.Kills, game.Deaths, game.Assists, formatRatio(game.KDA))
+	}
+
+	b.WriteString("\n## Weapons\n\n")
+	b.WriteString("| Weapon | Kills | Games |\n")
+	b.WriteString("|---|---|---|\n")
+	for _, weapon := range s.Weapons {
+		fmt.Fprintf(&b, "| %s | %d | %d |\n", markdownCell(weapon.Name), weapon.Kills, weapon.Games)
+	}
+
+	b.WriteString("\n## Builds\n\n")
+	b.WriteString("| Build | Games | Time worn |\n")
+	b.WriteString("|---|---|---|\n")
+	for _, snapshot := range s.Snapshots {
+		fmt.Fprintf(&b, "| %s | %d | %s |\n", markdownCell(snapshot.Name), snapshot.Games, formatDuration(snapshot.TimeWorn))
+	}
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+const (
+	svgWidth      = 600
+	svgLineHeight = 24
+)
+
+// writeSessionSVG draws a recap card with no external fonts or images, so it renders the same wherever it is shared.
+func writeSessionSVG(out io.Writer, export *SessionExport) error {
+	s := export.Summary
+	lines := []string{
+		fmt.Sprintf("%d-%d  ·  K/D %s  ·  KDA %s", s.Wins, s.Losses, formatRatio(s.KD), formatRatio(s.KDA)),
+		fmt.Sprintf("%d games  ·  %s played", s.Games, formatDuration(s.TimePlayed)),
+	}
+	if s.BestGame != nil {
+		lines = append(lines, fmt.Sprintf("Best game: %s, %.0f/%.0f/%.0f", s.BestGame.Location, s.BestGame.Kills, s.BestGame.Deaths, s.BestGame.Assists))
+	}
+	for i, weapon := range s.Weapons {
+		if i == 3 {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s  ·  %d kills", weapon.Name, weapon.Kills))
+	}
+	height := 80 + len(lines)*svgLineHeight + 20
+
+	winShare := 0.0
+	if s.Games > 0 {
+		winShare = float64(s.Wins) / float64(s.Games)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", svgWidth, height, svgWidth, height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" rx="12" fill="#1b1d26"/>`+"\n", svgWidth, height)
+	fmt.Fprintf(&b, `<rect x="24" y="56" width="%d" height="6" rx="3" fill="#8a3b3b"/>`+"\n", svgWidth-48)
+	fmt.Fprintf(&b, `<rect x="24" y="56" width="%d" height="6" rx="3" fill="#3b8a57"/>`+"\n", int(math.Round(winShare*float64(svgWidth-48))))
+	fmt.Fprintf(&b, `<text x="24" y="40" font-family="sans-serif" font-size="22" font-weight="bold" fill="#ffffff">%s</text>`+"\n", html.EscapeString(export.Name))
+	for i, line := range lines {
+		fmt.Fprintf(&b, `<text x="24" y="%d" font-family="sans-serif" font-size="16" fill="#d0d3de">%s</text>`+"\n", 90+i*svgLineHeight, html.EscapeString(line))
+	}
+	b.WriteString("</svg>\n")
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
@@ -0,0 +1,23 @@
+package main
+
+// DefaultLocale is the locale of the display properties stored directly on definitions
+const DefaultLocale = "en"
+
+// localizedDisplay returns the name and description in the locale, falling back to the default English text
+// when the definition was not migrated for it.
+func localizedDisplay(locale string, locales map[string]LocalizedDisplayProperties, name, description string) (string, string) {
+	if locale == "" || locale == DefaultLocale {
+		return name, description
+	}
+	localized, ok := locales[locale]
+	if !ok {
+		return name, description
+	}
+	if localized.Name != "" {
+		name = localized.Name
+	}
+	if localized.Description != "" {
+		description = localized.Description
+	}
+	return name, description
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// setupLogger configures the global zerolog logger to write Cloud Logging compatible JSON.
+// Cloud Logging reads the "severity", "message" and "timestamp" keys from structured payloads.
+func setupLogger() {
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	zerolog.TimestampFieldName = "timestamp"
+	zerolog.LevelFieldName = "severity"
+	zerolog.MessageFieldName = "message"
+	zerolog.LevelFieldMarshalFunc = severity
+
+	log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	// Anything logging from a context without a logger still ends up on the global one
+	zerolog.DefaultContextLogger = &log.Logger
+}
+
+// severity maps zerolog levels to the LogSeverity names understood by Cloud Logging.
+func severity(level zerolog.Level) string {
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return "DEBUG"
+	case zerolog.InfoLevel:
+		return "INFO"
+	case zerolog.WarnLevel:
+		return "WARNING"
+	case zerolog.ErrorLevel:
+		return "ERROR"
+	case zerolog.FatalLevel:
+		return "CRITICAL"
+	case zerolog.PanicLevel:
+		return "ALERT"
+	default:
+		return "DEFAULT"
+	}
+}
+
+// withLogFields returns a copy of ctx whose logger includes the provided key value pairs.
+// Every log line written through zerolog.Ctx on the returned context will carry them.
+func withLogFields(ctx context.Context, fields map[string]string) context.Context {
+	c := zerolog.Ctx(ctx).With()
+	for key, value := range fields {
+		c = c.Str(key, value)
+	}
+	l := c.Logger()
+	return l.WithContext(ctx)
+}
+
+// withSessionLogger attaches the session, user and character IDs to the context logger.
+func withSessionLogger(ctx context.Context, session Session) context.Context {
+	return withLogFields(ctx, map[string]string{
+		"sessionId":   session.ID,
+		"userId":      session.UserID,
+		"characterId": session.CharacterID,
+	})
+}
+
+// withActivityLogger attaches the activity instance ID to the context logger.
+func withActivityLogger(ctx context.Context, activityID string) context.Context {
+	return withLogFields(ctx, map[string]string{
+		"activityId": activityID,
+	})
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"serverTick/bungie"
@@ -9,7 +11,6 @@ import (
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -18,6 +19,14 @@ type Config struct {
 	attemptNum    string
 	DestinyAPIKey string
 	SkipSave      bool
+	// DryRun records every Firestore mutation into a change plan instead of applying it
+	DryRun bool
+	// DryRunOutput is the file the change plan is written to. Printed to stdout when empty
+	DryRunOutput string
+	// PGCRCache is the backend post game carnage reports are cached in: firestore, file or off
+	PGCRCache string
+	// PGCRCacheDir is the directory used by the file cache
+	PGCRCacheDir string
 }
 
 func configFromEnv() (Config, error) {
@@ -40,6 +49,22 @@ func configFromEnv() (Config, error) {
 	if skipSave == 1 {
 		config.SkipSave = true
 	}
+	dryRun, err := stringToInt(os.Getenv("DRY_RUN"))
+	if err != nil {
+		return Config{}, err
+	}
+	if dryRun == 1 {
+		config.DryRun = true
+		config.DryRunOutput = os.Getenv("DRY_RUN_OUTPUT")
+	}
+	config.PGCRCache = os.Getenv("PGCR_CACHE")
+	if config.PGCRCache == "" {
+		config.PGCRCache = defaultPGCRCache
+	}
+	config.PGCRCacheDir = os.Getenv("PGCR_CACHE_DIR")
+	if config.PGCRCacheDir == "" {
+		config.PGCRCacheDir = defaultPGCRCacheDir
+	}
 	return config, nil
 }
 
@@ -55,35 +80,58 @@ const (
 )
 
 func main() {
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	setupLogger()
+	if len(os.Args) > 1 {
+		err := runCLI(context.Background(), os.Args[1:])
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "%v\n\n%s\n", err, cliUsage)
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("command failed")
+		}
+		return
+	}
+
 	config, err := configFromEnv()
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to get config")
 	}
 	l := log.With().Int64("taskNum", config.taskNum).Logger()
-	ctx := context.Background()
+	ctx := l.WithContext(context.Background())
 
 	db, err := firestore.NewClient(ctx, projectID)
 	if err != nil {
 		l.Fatal().Err(err).Msgf("Failed to create client: %v", err)
 	}
+	if err := LoadActiveCollections(ctx, db); err != nil {
+		l.Fatal().Err(err).Msg("failed to load active manifest collections")
+	}
 
-	hc := http.Client{}
-	cli, err := bungie.NewClientWithResponses(
-		"https://www.bungie.net/Platform",
-		bungie.WithHTTPClient(&hc),
-		bungie.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
-			req.Header.Add("X-API-KEY", config.DestinyAPIKey)
-			req.Header.Add("Accept", "application/json")
-			req.Header.Add("Content-Type", "application/json")
-			req.Header.Add("User-Agent", "oneTrick-backend")
-			return nil
-		}),
-	)
+	cli, err := newDestinyClient(config.DestinyAPIKey)
 	if err != nil {
 		l.Fatal().Err(err).Msg("failed to start destiny client")
 	}
 
+	var w Writer = FirestoreWriter{}
+	if config.DryRun {
+		dryRun := NewDryRunWriter()
+		defer func() {
+			if err := dryRun.WritePlan(config.DryRunOutput); err != nil {
+				l.Error().Err(err).Msg("failed to write change plan")
+			}
+		}()
+		w = dryRun
+		l.Info().Msg("dry run enabled, no changes will be written to firestore")
+	}
+
+	cache, err := NewPGCRCache(config.PGCRCache, config.PGCRCacheDir, db, w)
+	if err != nil {
+		l.Fatal().Err(err).Msg("failed to create pgcr cache")
+	}
+
+	hooks := NewWebhooks(config.DryRun)
+
 	sessions, err := GetSessions(ctx, db)
 	if err != nil {
 		l.Fatal().Err(err).Msg("failed to get sessions")
@@ -91,168 +139,59 @@ func main() {
 
 	if len(sessions) == 0 {
 		l.Info().Msg("no sessions to process")
+		// Retries queued by earlier ticks are still due
+		hooks.DeliverPending(ctx, db, w)
 		return
 	}
 
 	l.Info().Int("sessions", len(sessions)).Msg("received sessions to process")
+	userIDs := make(map[string]bool)
 	for i, session := range sessions {
-
-		membershipType, membershipID, err := GetMembershipType(ctx, db, session.UserID)
-		if err != nil {
-			l.Error().Err(err).Msg("failed to fetch membership type")
-			continue
-		}
-
-		// This could be moved to something else in the future maybe. It's not super necessary
-		// that it is done here before the rest of the logic. Just that it is done
-		ll := l.With().Str("session", session.ID).Int("count", i).Logger()
-		if !config.SkipSave {
-			ll.Info().Msg("starting to save loadout")
-			startTime := time.Now()
-			_, err = Save(ctx, db, cli, session.UserID, membershipID, session.CharacterID)
-			if err != nil {
-				ll.Warn().Err(err).Msg("failed to save loadout")
-				continue
-			}
-			ll.Info().
-				TimeDiff("loadoutDuration", time.Now(), startTime).
-				Msg("saved loadout")
-
-		}
-		ll.Info().Msg("starting to get pvp games")
-		startTime := time.Now()
-		// Activity history should be shared
-		activityHistories, err := GetAllPVP(
-			ctx,
-			cli,
-			db,
-			membershipID,
-			membershipType,
-			session.CharacterID,
-			2,
-			0,
-		)
+		linked, err := ProcessSession(ctx, db, w, cli, cache, hooks, config, session)
 		if err != nil {
-			ll.Error().Err(err).Msg("[SKIP]: failed to get activities")
+			l.Error().Err(err).Str("sessionId", session.ID).Int("count", i).Msg("failed to process session")
 			continue
 		}
-		ll.Info().
-			TimeDiff("pvpDuration", time.Now(), startTime).
-			Msg("got pvp response")
-
-		if len(activityHistories) == 0 {
-			ll.Warn().Msg("[SKIP]: no history found for user")
-			continue
-		}
-
-		latest := activityHistories[0]
-
-		if session.LastSeenActivityID != nil && *session.LastSeenActivityID == latest.InstanceID {
-			ll.Info().Msg("[SKIP]: No new activities since last check-in")
-			if IsStaleSession(session, latest) {
-				err := EndSession(ctx, db, session.ID)
-				if err != nil {
-					ll.Error().Err(err).Msg("failed to end session")
-					continue
-				}
-				ll.Info().Msg("session is stale. Ending session")
-				continue
-			}
-			continue
+		// Rollups only change when games were added, so skipped sessions leave them alone
+		if linked > 0 {
+			userIDs[session.UserID] = true
 		}
+	}
+	l.Info().Msg("finished going through all sessions")
+	hooks.DeliverPending(ctx, db, w)
 
-		IDs := make([]string, 0)
-		histories := make([]ActivityHistory, 0)
-		// Only choose activities that happened after starting the session
-		gracePeriod := session.StartedAt.Add(-15 * time.Minute)
-		for _, activity := range activityHistories {
-			if activity.Period.After(gracePeriod) {
-				IDs = append(IDs, activity.InstanceID)
-				histories = append(histories, activity)
-			}
-		}
-
-		if len(IDs) == 0 {
-			l.Info().Msg("[SKIP]: No new activity to save. Checking if Inactive")
-			if IsInactiveSession(session) {
-				err := EndSession(ctx, db, session.ID)
-				if err != nil {
-					ll.Error().Err(err).Msg("failed to end session")
-					continue
-				}
-				ll.Info().Msg("session is inactive. Ending session")
-				continue
-			}
-			continue
-		}
-
-		ll.Info().Strs("IDs", IDs).Msg("Activities Found")
-
-		existingAggs, err := GetAggregatesByActivity(ctx, db, IDs)
+	if len(userIDs) == 0 {
+		return
+	}
+	now := time.Now()
+	windows, err := rollupWindows(ctx, db, now)
+	if err != nil {
+		l.Error().Err(err).Msg("failed to resolve rollup windows")
+		return
+	}
+	for userID := range userIDs {
+		user, err := GetUser(ctx, db, userID)
 		if err != nil {
-			ll.Error().
-				Err(err).
-				Strs("activityIDs", IDs).Msg("failed to fetch aggregates by the provided IDs")
+			l.Error().Err(err).Str("userId", userID).Msg("failed to fetch user for rollups")
 			continue
 		}
-
-		ll.Info().Msgf("Length of existing Aggs: %d", len(existingAggs))
-
-		existingAggMap := make(map[string]*Aggregate)
-		for _, agg := range existingAggs {
-			existingAggMap[agg.ActivityID] = &agg
-		}
-
-		aggIDs := make([]string, 0)
-		// TODO: Maybe this should be after total success at the end of the loop
-		err = SetLastActivity(ctx, db, session.ID, latest.InstanceID)
-		if err != nil {
-			l.Warn().Err(err).Msg("failed to save last activity for session. Continuing on")
-		}
-		for _, history := range histories {
-			agg := existingAggMap[history.InstanceID]
-
-			link := LookupLink(agg, session.CharacterID)
-			// Already attempted to link this character to this activity so we can skip it
-			if link != nil && link.SessionID != nil {
-				ll.Info().Str("activityId", history.InstanceID).Msg("Already linked to this activity")
-				continue
-			}
-
-			performances, err := GetPerformances(ctx, cli, db, history.InstanceID, session.CharacterID)
-			if err != nil {
-				l.Error().Err(err).Msg("failed to fetch performances")
-				continue
-			}
-			performance, ok := performances[session.CharacterID]
-			if !ok {
-				ll.Warn().Str("userId", session.UserID).Msg("no performance found for member")
-				continue
-			}
-			a, err := SetAggregate(
-				ctx,
-				db,
-				session.UserID,
-				session.CharacterID,
-				history,
-				history.Period,
-				performance,
-				session.ID,
-			)
-			if err != nil {
-				l.Error().Err(err).Msg("failed to add data to aggregate")
-				continue
-			}
-			aggIDs = append(aggIDs, a.ID)
-		}
-		l.Info().Strs("aggregateIds", aggIDs).Msgf("Aggregates to add")
-
-		err = AddAggregateIDs(ctx, db, session.ID, aggIDs)
-		if err != nil {
-			l.Error().Err(err).Msg("Failed to add aggregate IDs to session")
-			continue
+		if err := RollupUser(ctx, db, w, *user, windows, now); err != nil {
+			l.Error().Err(err).Str("userId", userID).Msg("failed to roll up user stats")
 		}
-		l.Info().Strs("aggregates", aggIDs).Msg("Added aggregate IDs to session")
 	}
-	l.Info().Msg("finished going through all sessions")
+}
+
+func newDestinyClient(apiKey string) (*bungie.ClientWithResponses, error) {
+	hc := http.Client{}
+	return bungie.NewClientWithResponses(
+		"https://www.bungie.net/Platform",
+		bungie.WithHTTPClient(&hc),
+		bungie.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+			req.Header.Add("X-API-KEY", apiKey)
+			req.Header.Add("Accept", "application/json")
+			req.Header.Add("Content-Type", "application/json")
+			req.Header.Add("User-Agent", "oneTrick-backend")
+			return nil
+		}),
+	)
 }
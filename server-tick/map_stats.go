@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+)
+
+const (
+	mapStatsCollection = "mapStats"
+	// mapTopWeapons is how many of the most used weapons are kept per map
+	mapTopWeapons = 5
+)
+
+// MapStats is a user's performance on every crucible map, one document per user so the app reads it in one call.
+type MapStats struct {
+	UserID     string    `firestore:"userId" json:"userId"`
+	ComputedAt time.Time `firestore:"computedAt" json:"computedAt"`
+	// Windows is keyed by window name: 7d, 30d or season
+	Windows map[string]MapStatsWindow `firestore:"windows" json:"windows"`
+}
+
+type MapStatsWindow struct {
+	From time.Time `firestore:"from" json:"from"`
+	// Characters maps each character to its performance keyed by map key
+	Characters map[string]map[string]MapPerformance `firestore:"characters" json:"characters"`
+	// All is every character combined, keyed by map key
+	All map[string]MapPerformance `firestore:"all" json:"all"`
+}
+
+type MapPerformance struct {
+	MapKey   string `firestore:"mapKey" json:"mapKey"`
+	Location string `firestore:"location" json:"location"`
+	CombatTotals
+	// Weapons The most used weapons on the map, by kills
+	Weapons []MapWeaponUsage `firestore:"weapons" json:"weapons"`
+
+	weaponUsage map[int64]*MapWeaponUsage
+}
+
+type MapWeaponUsage struct {
+	ReferenceID int64  `firestore:"referenceId" json:"referenceId"`
+	Name        string `firestore:"name" json:"name"`
+	Kills       int64  `firestore:"kills" json:"kills"`
+	Games       int    `firestore:"games" json:"games"`
+}
+
+func (p *MapPerformance) add(history ActivityHistory, performance InstancePerformance) {
+	p.Location = history.Location
+	p.CombatTotals.add(performance.PlayerStats)
+	if p.weaponUsage == nil {
+		p.weaponUsage = make(map[int64]*MapWeaponUsage)
+	}
+	for _, weapon := range performance.Weapons {
+		if weapon.ReferenceID == nil {
+			continue
+		}
+		usage, ok := p.weaponUsage[*weapon.ReferenceID]
+		if !ok {
+			usage = &MapWeaponUsage{ReferenceID: *weapon.ReferenceID}
+			p.weaponUsage[*weapon.ReferenceID] = usage
+		}
+		if weapon.Display != nil {
+			usage.Name = weapon.Display.Name
+		}
+		usage.Kills += int64(uniqueStatValue(weapon.Stats, "uniqueWeaponKills"))
+		usage.Games++
+	}
+}
+
+func (p *MapPerformance) finish() {
+	p.CombatTotals.finish()
+	p.Weapons = make([]MapWeaponUsage, 0, len(p.weaponUsage))
+	for _, usage := range p.weaponUsage {
+		p.Weapons = append(p.Weapons, *usage)
+	}
+	sort.Slice(p.Weapons, func(i, j int) bool {
+		if p.Weapons[i].Kills != p.Weapons[j].Kills {
+			return p.Weapons[i].Kills > p.Weapons[j].Kills
+		}
+		return p.Weapons[i].ReferenceID < p.Weapons[j].ReferenceID
+	})
+	if len(p.Weapons) > mapTopWeapons {
+		p.Weapons = p.Weapons[:mapTopWeapons]
+	}
+}
+
+// ComputeMapStats totals the user's crucible games by map for every rollup window. Games without a map key, like
+// those played before map keys were resolved, are left out.
+func ComputeMapStats(user User, source *rollupSource) *MapStats {
+	windows := source.Windows
+	characters := make(map[string]map[string]map[string]*MapPerformance)
+	all := make(map[string]map[string]*MapPerformance)
+	for _, window := range windows {
+		characters[window.Name] = make(map[string]map[string]*MapPerformance)
+		all[window.Name] = make(map[string]*MapPerformance)
+	}
+	forEachGame(user, source, func(game rollupGame, in []RollupWindow) {
+		mapKey := game.Aggregate.MapKey
+		if mapKey == "" {
+			return
+		}
+		for _, window := range in {
+			byMap := characters[window.Name][game.CharacterID]
+			if byMap == nil {
+				byMap = make(map[string]*MapPerformance)
+				characters[window.Name][game.CharacterID] = byMap
+			}
+			for _, totals := range []map[string]*MapPerformance{byMap, all[window.Name]} {
+				p, ok := totals[mapKey]
+				if !ok {
+					p = &MapPerformance{MapKey: mapKey}
+					totals[mapKey] = p
+				}
+				p.add(game.Aggregate.ActivityDetails, game.Performance)
+			}
+		}
+	})
+
+	stats := &MapStats{UserID: user.ID, ComputedAt: source.Now, Windows: make(map[string]MapStatsWindow, len(windows))}
+	for _, window := range windows {
+		result := MapStatsWindow{
+			From:       window.From,
+			Characters: make(map[string]map[string]MapPerformance),
+			All:        finishMapPerformances(all[window.Name]),
+		}
+		for characterID, byMap := range characters[window.Name] {
+			result.Characters[characterID] = finishMapPerformances(byMap)
+		}
+		stats.Windows[window.Name] = result
+	}
+	return stats
+}
+
+func finishMapPerformances(totals map[string]*MapPerformance) map[string]MapPerformance {
+	result := make(map[string]MapPerformance, len(totals))
+	for key, p := range totals {
+		p.finish()
+		result[key] = *p
+	}
+	return result
+}
+
+// RollupMapStats recomputes and stores the user's map stats.
+func RollupMapStats(ctx context.Context, db *firestore.Client, w Writer, user User, source *rollupSource) (*MapStats, error) {
+	stats := ComputeMapStats(user, source)
+	if err := w.Set(ctx, db.Collection(mapStatsCollection).Doc(user.ID), stats); err != nil {
+		return nil, fmt.Errorf("failed to save map stats: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("userId", user.ID).
+		Int("games", stats.Windows[WindowMonth].gameCount()).
+		Msg("rolled up map stats")
+	return stats, nil
+}
+
+func (w MapStatsWindow) gameCount() int {
+	games := 0
+	for _, p := range w.All {
+		games += p.Games
+	}
+	return games
+}
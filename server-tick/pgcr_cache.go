@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	pgcrCacheCollection = "pgcrCache"
+	// maxCachedPGCRSize keeps compressed payloads under the Firestore document limit of 1 MiB
+	maxCachedPGCRSize   = 900 * 1024
+	defaultPGCRCacheDir = "./pgcr-cache"
+	// defaultPGCRCache leaves caching off unless a backend is picked, so nothing is written to Firestore by surprise
+	defaultPGCRCache = "off"
+)
+
+// ErrCacheMiss is returned by a PGCRCache when the report has not been stored yet.
+var ErrCacheMiss = errors.New("pgcr not cached")
+
+// PGCRCache stores raw post game carnage report payloads keyed by activity instance ID.
+// Implementations receive and return the uncompressed payload and handle compression themselves.
+type PGCRCache interface {
+	Get(ctx context.Context, instanceID string) ([]byte, error)
+	Put(ctx context.Context, instanceID string, payload []byte) error
+}
+
+// NewPGCRCache builds the cache backend by name: "firestore", "file" or "off". An empty name is off.
+func NewPGCRCache(kind, dir string, db *firestore.Client, w Writer) (PGCRCache, error) {
+	switch kind {
+	case "firestore":
+		return &FirestorePGCRCache{db: db, w: w}, nil
+	case "file":
+		return &FilePGCRCache{Dir: dir}, nil
+	case "", "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown pgcr cache %q", kind)
+	}
+}
+
+// FilePGCRCache keeps gzip compressed reports as files in Dir. Pointing Dir at a Cloud Storage bucket
+// mounted as a volume shares the cache between runs.
+type FilePGCRCache struct {
+	Dir string
+}
+
+func (c *FilePGCRCache) path(instanceID string) string {
+	return filepath.Join(c.Dir, instanceID+".json.gz")
+}
+
+func (c *FilePGCRCache) Get(_ context.Context, instanceID string) ([]byte, error) {
+	data, err := os.ReadFile(c.path(instanceID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decompress(data)
+}
+
+func (c *FilePGCRCache) Put(_ context.Context, instanceID string, payload []byte) error {
+	data, err := compress(payload)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	// Write to a temp file first so a reader never sees a partial report
+	tmp, err := os.CreateTemp(c.Dir, instanceID+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(instanceID))
+}
+
+// FirestorePGCRCache keeps gzip compressed reports as documents in the pgcrCache collection.
+type FirestorePGCRCache struct {
+	db *firestore.Client
+	w  Writer
+}
+
+type cachedPGCR struct {
+	InstanceID string    `firestore:"instanceId"`
+	Data       []byte    `firestore:"data"`
+	CreatedAt  time.Time `firestore:"createdAt"`
+}
+
+func (c *FirestorePGCRCache) Get(ctx context.Context, instanceID string) ([]byte, error) {
+	doc, err := c.db.Collection(pgcrCacheCollection).Doc(instanceID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached := cachedPGCR{}
+	if err := doc.DataTo(&cached); err != nil {
+		return nil, err
+	}
+	return decompress(cached.Data)
+}
+
+func (c *FirestorePGCRCache) Put(ctx context.Context, instanceID string, payload []byte) error {
+	data, err := compress(payload)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxCachedPGCRSize {
+		return fmt.Errorf("compressed report is %d bytes, too large to cache", len(data))
+	}
+	return c.w.Set(ctx, c.db.Collection(pgcrCacheCollection).Doc(instanceID), cachedPGCR{
+		InstanceID: instanceID,
+		Data:       data,
+		CreatedAt:  time.Now(),
+	})
+}
+
+func compress(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached report: %w", err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
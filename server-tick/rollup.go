@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// RollupWindow is a rolling period rollups are computed over.
+type RollupWindow struct {
+	Name string
+	From time.Time
+}
+
+const (
+	WindowWeek   = "7d"
+	WindowMonth  = "30d"
+	WindowSeason = "season"
+)
+
+// rollupWindows returns the last 7 and 30 days, and the current season when the manifest knows of one.
+func rollupWindows(ctx context.Context, db *firestore.Client, now time.Time) ([]RollupWindow, error) {
+	windows := []RollupWindow{
+		{Name: WindowWeek, From: now.AddDate(0, 0, -7)},
+		{Name: WindowMonth, From: now.AddDate(0, 0, -30)},
+	}
+	season, err := GetCurrentSeason(ctx, db, now)
+	if err != nil {
+		return nil, err
+	}
+	if season != nil {
+		start, _ := time.Parse(time.RFC3339, season.StartDate)
+		windows = append(windows, RollupWindow{Name: WindowSeason, From: start})
+	}
+	return windows, nil
+}
+
+// rollupSource is what a user's rollups are computed from, read once and shared by all of them.
+type rollupSource struct {
+	Now     time.Time
+	Windows []RollupWindow
+	// Aggregates holds every game of each character since the start of the longest window or the skill trend, oldest
+	// first
+	Aggregates map[string][]Aggregate
+}
+
+// loadRollupSource reads every character's games over the widest range any rollup needs.
+func loadRollupSource(ctx context.Context, db *firestore.Client, user User, windows []RollupWindow, now time.Time) (*rollupSource, error) {
+	from := earliest(windows)
+	if trendFrom := now.AddDate(0, 0, -trendDays); trendFrom.Before(from) {
+		from = trendFrom
+	}
+	source := &rollupSource{Now: now, Windows: windows, Aggregates: make(map[string][]Aggregate, len(user.CharacterIDs))}
+	for _, characterID := range user.CharacterIDs {
+		aggregates, err := GetCharacterAggregates(ctx, db, characterID, from)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch aggregates for character %s: %w", characterID, err)
+		}
+		source.Aggregates[characterID] = aggregates
+	}
+	return source, nil
+}
+
+// RollupUser recomputes every rollup for the user over the given windows, resolved once by the caller so a tick
+// rolling up many users looks the season up once.
+func RollupUser(ctx context.Context, db *firestore.Client, w Writer, user User, windows []RollupWindow, now time.Time) error {
+	source, err := loadRollupSource(ctx, db, user, windows, now)
+	if err != nil {
+		return err
+	}
+	if _, err := RollupMapStats(ctx, db, w, user, source); err != nil {
+		return fmt.Errorf("map stats: %w", err)
+	}
+	if _, err := RollupWeaponStats(ctx, db, w, user, source); err != nil {
+		return fmt.Errorf("weapon stats: %w", err)
+	}
+	if err := RollupSkillTrends(ctx, db, w, user, source); err != nil {
+		return fmt.Errorf("skill trends: %w", err)
+	}
+	return nil
+}
+
+// rollupGame is a single game a user's character played, as counted by rollups.
+type rollupGame struct {
+	CharacterID string
+	Aggregate   Aggregate
+	Performance InstancePerformance
+}
+
+// forEachGame calls fn for every game the user played in at least one of the windows, with the windows the game
+// falls in.
+func forEachGame(user User, source *rollupSource, fn func(game rollupGame, windows []RollupWindow)) {
+	for _, characterID := range user.CharacterIDs {
+		for _, aggregate := range source.Aggregates[characterID] {
+			performance, ok := aggregate.Performance[characterID]
+			if !ok {
+				continue
+			}
+			in := make([]RollupWindow, 0, len(source.Windows))
+			for _, window := range source.Windows {
+				if !aggregate.ActivityDetails.Period.Before(window.From) {
+					in = append(in, window)
+				}
+			}
+			if len(in) == 0 {
+				continue
+			}
+			fn(rollupGame{CharacterID: characterID, Aggregate: aggregate, Performance: performance}, in)
+		}
+	}
+}
+
+// earliest returns the start of the longest window.
+func earliest(windows []RollupWindow) time.Time {
+	from := windows[0].From
+	for _, window := range windows[1:] {
+		if window.From.Before(from) {
+			from = window.From
+		}
+	}
+	return from
+}
+
+func statValue(pair *StatsValuePair) float64 {
+	if pair == nil || pair.Value == nil {
+		return 0
+	}
+	return *pair.Value
+}
+
+func uniqueStatValue(stats *map[string]UniqueStatValue, key string) float64 {
+	if stats == nil {
+		return 0
+	}
+	value, ok := (*stats)[key]
+	if !ok {
+		return 0
+	}
+	return statValue(&value.Basic)
+}
+
+// isWin reports whether the player's team won. Bungie's standing is 0 for a victory.
+func isWin(stats PlayerStats) bool {
+	return stats.Standing != nil && stats.Standing.Value != nil && *stats.Standing.Value == 0
+}
+
+// ratio divides a by b, treating no deaths as one so a flawless game keeps its kills as the ratio.
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return a
+	}
+	return a / b
+}
+
+// CombatTotals are the summed kills, deaths and assists over a set of games, with the ratios derived from them.
+type CombatTotals struct {
+	Games      int     `firestore:"games" json:"games"`
+	Wins       int     `firestore:"wins" json:"wins"`
+	Kills      float64 `firestore:"kills" json:"kills"`
+	Deaths     float64 `firestore:"deaths" json:"deaths"`
+	Assists    float64 `firestore:"assists" json:"assists"`
+	TimePlayed float64 `firestore:"timePlayed" json:"timePlayed"`
+	WinRate    float64 `firestore:"winRate" json:"winRate"`
+	KD         float64 `firestore:"kd" json:"kd"`
+	// KDA Bungie's kills + half of assists over deaths
+	KDA float64 `firestore:"kda" json:"kda"`
+	// Efficiency kills + assists over deaths
+	Efficiency        float64 `firestore:"efficiency" json:"efficiency"`
+	AverageTimePlayed float64 `firestore:"averageTimePlayed" json:"averageTimePlayed"`
+}
+
+// add counts a single game.
+func (t *CombatTotals) add(stats PlayerStats) {
+	t.Games++
+	if isWin(stats) {
+		t.Wins++
+	}
+	t.Kills += statValue(stats.Kills)
+	t.Deaths += statValue(stats.Deaths)
+	t.Assists += statValue(stats.Assists)
+	t.TimePlayed += statValue(stats.TimePlayed)
+}
+
+// finish derives the rates from the totals.
+func (t *CombatTotals) finish() {
+	if t.Games == 0 {
+		return
+	}
+	t.WinRate = float64(t.Wins) / float64(t.Games)
+	t.KD = ratio(t.Kills, t.Deaths)
+	t.KDA = ratio(t.Kills+t.Assists/2, t.Deaths)
+	t.Efficiency = ratio(t.Kills+t.Assists, t.Deaths)
+	t.AverageTimePlayed = t.TimePlayed / float64(t.Games)
+}
@@ -3,14 +3,19 @@ package main
 import (
 	"context"
 	"fmt"
+	"serverTick/utils"
 	"time"
 
 	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
 )
 
 const (
 	SessionCollection = "sessions"
 	CutOffHours       = 4
+	// startedEventMaxAge is how old a session can be when first tracked and still announce it started. Sessions
+	// that were already pending when webhooks rolled out are marked tracked without a stale event
+	startedEventMaxAge = 30 * time.Minute
 )
 
 func IsStaleSession(s Session, activity ActivityHistory) bool {
@@ -54,8 +59,34 @@ func GetSessions(ctx context.Context, db *firestore.Client) ([]Session, error) {
 	return sessions, nil
 }
 
-func SetLastActivity(ctx context.Context, db *firestore.Client, ID, activityID string) error {
-	_, err := db.Collection(SessionCollection).Doc(ID).Update(ctx, []firestore.Update{
+// GetSession fetches a single session by ID regardless of its status.
+func GetSession(ctx context.Context, db *firestore.Client, ID string) (*Session, error) {
+	doc, err := db.Collection(SessionCollection).Doc(ID).Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	s := Session{}
+	if err := doc.DataTo(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetUserSessions fetches all pending sessions for the user.
+func GetUserSessions(ctx context.Context, db *firestore.Client, userID string) ([]Session, error) {
+	docs, err := db.Collection(SessionCollection).
+		Where("status", "==", "pending").
+		Where("userId", "==", userID).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return utils.GetAllToStructs[Session](docs)
+}
+
+func SetLastActivity(ctx context.Context, db *firestore.Client, w Writer, ID, activityID string) error {
+	err := w.Update(ctx, db.Collection(SessionCollection).Doc(ID), []firestore.Update{
 		{
 			Path:  "lastSeenActivityId",
 			Value: activityID,
@@ -75,13 +106,15 @@ func SetLastActivity(ctx context.Context, db *firestore.Client, ID, activityID s
 	return nil
 }
 
-func EndSession(ctx context.Context, db *firestore.Client, ID string) error {
+// EndSession marks the session complete with the reason it ended and stores its summary. A summary that fails to
+// compute is logged and left out rather than keeping the session open.
+func EndSession(ctx context.Context, db *firestore.Client, w Writer, hooks *Webhooks, session Session, reason SessionEndReason) error {
 	completedBy := AuditField{
 		ID:       "system",
 		Username: "system",
 	}
 	now := time.Now()
-	_, err := db.Collection(SessionCollection).Doc(ID).Update(ctx, []firestore.Update{
+	updates := []firestore.Update{
 		{
 			Path:  "completedBy",
 			Value: completedBy,
@@ -94,26 +127,58 @@ func EndSession(ctx context.Context, db *firestore.Client, ID string) error {
 			Path:  "completedAt",
 			Value: now,
 		},
+		{
+			Path:  "endReason",
+			Value: reason,
+		},
 		{
 			Path:  "updatedAt",
 			Value: now,
 		},
-	})
+	}
+	summary, err := ComputeSessionSummary(ctx, db, session)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("sessionId", session.ID).Msg("failed to compute session summary")
+	} else {
+		updates = append(updates, firestore.Update{Path: "summary", Value: summary})
+	}
+	err = w.Update(ctx, db.Collection(SessionCollection).Doc(session.ID), updates)
 	if err != nil {
 		return fmt.Errorf("failed to end session: %v", err)
 	}
+	hooks.Send(ctx, db, w, session, SessionEndedEvent, SessionEndedData{Reason: reason, Summary: summary})
+	return nil
+}
+
+// MarkSessionTracked records the first tick that picked the session up and lets the user's webhooks know when the
+// session started recently.
+func MarkSessionTracked(ctx context.Context, db *firestore.Client, w Writer, hooks *Webhooks, session Session) error {
+	err := w.Update(ctx, db.Collection(SessionCollection).Doc(session.ID), []firestore.Update{
+		{
+			Path:  "trackedAt",
+			Value: time.Now(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark session tracked: %w", err)
+	}
+	if time.Since(session.StartedAt) > startedEventMaxAge {
+		zerolog.Ctx(ctx).Info().Time("startedAt", session.StartedAt).Msg("session started too long ago to announce")
+		return nil
+	}
+	hooks.Send(ctx, db, w, session, SessionStartedEvent, SessionStartedData{Name: session.Name, StartedAt: session.StartedAt})
 	return nil
 }
 
-func AddAggregateIDs(ctx context.Context, db *firestore.Client, sessionID string, aggregateIDs []string) error {
+func AddAggregateIDs(ctx context.Context, db *firestore.Client, w Writer, sessionID string, aggregateIDs []string) error {
 	ids := make([]any, 0)
 	for _, d := range aggregateIDs {
 		ids = append(ids, d)
 	}
-	_, err := db.Collection(SessionCollection).Doc(sessionID).Update(ctx, []firestore.Update{
+	err := w.Update(ctx, db.Collection(SessionCollection).Doc(sessionID), []firestore.Update{
 		{
 			Path:  "aggregateIds",
-			Value: firestore.ArrayUnion(ids...),
+			Value: ArrayUnion(ids...),
 		},
 	})
 	if err != nil {
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+)
+
+const (
+	WinStreak  = "win"
+	LossStreak = "loss"
+)
+
+// SessionSummary is the recap of a session stored on the session document when it ends, so the app doesn't need to
+// read every aggregate.
+type SessionSummary struct {
+	CombatTotals
+	Losses int `firestore:"losses" json:"losses"`
+	// BestGame The game with the highest KDA
+	BestGame *SessionGame `firestore:"bestGame" json:"bestGame,omitempty"`
+	// Matches Every game of the session, oldest first
+	Matches []SessionGame `firestore:"matches" json:"matches"`
+	// Weapons Every weapon used, most kills first
+	Weapons []SessionWeapon `firestore:"weapons" json:"weapons"`
+	// Snapshots Every build worn, in the order they were first worn
+	Snapshots []SessionSnapshot `firestore:"snapshots" json:"snapshots"`
+	Streak    SessionStreak     `firestore:"streak" json:"streak"`
+	CreatedAt time.Time         `firestore:"createdAt" json:"createdAt"`
+}
+
+type SessionGame struct {
+	AggregateID string    `firestore:"aggregateId" json:"aggregateId"`
+	ActivityID  string    `firestore:"activityId" json:"activityId"`
+	Location    string    `firestore:"location" json:"location"`
+	Mode        string    `firestore:"mode" json:"mode"`
+	Period      time.Time `firestore:"period" json:"period"`
+	Win         bool      `firestore:"win" json:"win"`
+	Kills       float64   `firestore:"kills" json:"kills"`
+	Deaths      float64   `firestore:"deaths" json:"deaths"`
+	Assists     float64   `firestore:"assists" json:"assists"`
+	KDA         float64   `firestore:"kda" json:"kda"`
+	SnapshotID  *string   `firestore:"snapshotId" json:"snapshotId,omitempty"`
+}
+
+type SessionWeapon struct {
+	ReferenceID int64   `firestore:"referenceId" json:"referenceId"`
+	Name        string  `firestore:"name" json:"name"`
+	Icon        *string `firestore:"icon" json:"icon,omitempty"`
+	Kills       int64   `firestore:"kills" json:"kills"`
+	Games       int     `firestore:"games" json:"games"`
+}
+
+type SessionSnapshot struct {
+	SnapshotID string `firestore:"snapshotId" json:"snapshotId"`
+	Name       string `firestore:"name" json:"name"`
+	Games      int    `firestore:"games" json:"games"`
+	// TimeWorn Seconds played in games linked to the snapshot
+	TimeWorn float64 `firestore:"timeWorn" json:"timeWorn"`
+}
+
+// SessionStreak is the run of wins or losses the session ended on, and the longest run of wins in it.
+type SessionStreak struct {
+	Type          string `firestore:"type" json:"type"`
+	Length        int    `firestore:"length" json:"length"`
+	LongestWinRun int    `firestore:"longestWinRun" json:"longestWinRun"`
+}
+
+// GetAggregatesByIDs fetches the aggregates with the given document IDs. Missing aggregates are skipped.
+func GetAggregatesByIDs(ctx context.Context, db *firestore.Client, IDs []string) ([]Aggregate, error) {
+	if len(IDs) == 0 {
+		return nil, nil
+	}
+	refs := make([]*firestore.DocumentRef, 0, len(IDs))
+	for _, ID := range IDs {
+		refs = append(refs, db.Collection(aggregateCollection).Doc(ID))
+	}
+	docs, err := db.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	aggregates := make([]Aggregate, 0, len(docs))
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		aggregate := Aggregate{}
+		if err := doc.DataTo(&aggregate); err != nil {
+			return nil, fmt.Errorf("failed to convert aggregate %s: %w", doc.Ref.ID, err)
+		}
+		aggregates = append(aggregates, aggregate)
+	}
+	return aggregates, nil
+}
+
+// NewSessionGame is how the character did in the aggregate's game.
+func NewSessionGame(aggregate Aggregate, characterID string, performance InstancePerformance) SessionGame {
+	stats := performance.PlayerStats
+	game := SessionGame{
+		AggregateID: aggregate.ID,
+		ActivityID:  aggregate.ActivityID,
+		Location:    aggregate.ActivityDetails.Location,
+		Period:      aggregate.ActivityDetails.Period,
+		Win:         isWin(stats),
+		Kills:       statValue(stats.Kills),
+		Deaths:      statValue(stats.Deaths),
+		Assists:     statValue(stats.Assists),
+	}
+	game.KDA = ratio(game.Kills+game.Assists/2, game.Deaths)
+	if aggregate.ActivityDetails.Mode != nil {
+		game.Mode = *aggregate.ActivityDetails.Mode
+	}
+	if link := LookupLink(&aggregate, characterID); link != nil {
+		game.SnapshotID = link.SnapshotID
+	}
+	return game
+}
+
+// ComputeSessionSummary recaps the games the session's character played in the session's aggregates.
+func ComputeSessionSummary(ctx context.Context, db *firestore.Client, session Session) (*SessionSummary, error) {
+	aggregates, err := GetAggregatesByIDs(ctx, db, session.AggregateIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch session aggregates: %w", err)
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		return aggregates[i].ActivityDetails.Period.Before(aggregates[j].ActivityDetails.Period)
+	})
+
+	summary := &SessionSummary{
+		Matches:   make([]SessionGame, 0, len(aggregates)),
+		Weapons:   make([]SessionWeapon, 0),
+		Snapshots: make([]SessionSnapshot, 0),
+		CreatedAt: time.Now(),
+	}
+	weapons := make(map[int64]*SessionWeapon)
+	snapshots := make(map[string]*SessionSnapshot)
+	snapshotOrder := make([]string, 0)
+	winRun := 0
+	for _, aggregate := range aggregates {
+		performance, ok := aggregate.Performance[session.CharacterID]
+		if !ok {
+			continue
+		}
+		stats := performance.PlayerStats
+		summary.add(stats)
+
+		game := NewSessionGame(aggregate, session.CharacterID, performance)
+		if link := LookupLink(&aggregate, session.CharacterID); link != nil && link.SnapshotID != nil {
+			snapshot, ok := snapshots[*link.SnapshotID]
+			if !ok {
+				snapshot = &SessionSnapshot{SnapshotID: *link.SnapshotID}
+				snapshots[*link.SnapshotID] = snapshot
+				snapshotOrder = append(snapshotOrder, *link.SnapshotID)
+			}
+			snapshot.Games++
+			snapshot.TimeWorn += statValue(stats.TimePlayed)
+		}
+		summary.Matches = append(summary.Matches, game)
+		if summary.BestGame == nil || game.KDA > summary.BestGame.KDA {
+			best := game
+			summary.BestGame = &best
+		}
+
+		for _, metrics := range performance.Weapons {
+			if metrics.ReferenceID == nil {
+				continue
+			}
+			weapon, ok := weapons[*metrics.ReferenceID]
+			if !ok {
+				weapon = &SessionWeapon{ReferenceID: *metrics.ReferenceID}
+				weapons[*metrics.ReferenceID] = weapon
+			}
+			if metrics.Display != nil {
+				weapon.Name = metrics.Display.Name
+				weapon.Icon = metrics.Display.Icon
+			}
+			weapon.Kills += int64(uniqueStatValue(metrics.Stats, "uniqueWeaponKills"))
+			weapon.Games++
+		}
+
+		streakType := LossStreak
+		if game.Win {
+			streakType = WinStreak
+			winRun++
+			summary.Streak.LongestWinRun = max(summary.Streak.LongestWinRun, winRun)
+		} else {
+			winRun = 0
+		}
+		if summary.Streak.Type == streakType {
+			summary.Streak.Length++
+		} else {
+			summary.Streak = SessionStreak{Type: streakType, Length: 1, LongestWinRun: summary.Streak.LongestWinRun}
+		}
+	}
+	summary.finish()
+	summary.Losses = summary.Games - summary.Wins
+
+	for _, weapon := range weapons {
+		summary.Weapons = append(summary.Weapons, *weapon)
+	}
+	sort.Slice(summary.Weapons, func(i, j int) bool {
+		if summary.Weapons[i].Kills != summary.Weapons[j].Kills {
+			return summary.Weapons[i].Kills > summary.Weapons[j].Kills
+		}
+		return summary.Weapons[i].ReferenceID < summary.Weapons[j].ReferenceID
+	})
+
+	for _, ID := range snapshotOrder {
+		snapshot := snapshots[ID]
+		if s, err := Get(ctx, db, ID); err == nil && s != nil {
+			snapshot.Name = s.Name
+		} else {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("snapshotId", ID).Msg("failed to get snapshot name for summary")
+		}
+		summary.Snapshots = append(summary.Snapshots, *snapshot)
+	}
+	zerolog.Ctx(ctx).Debug().
+		Str("sessionId", session.ID).
+		Int("games", summary.Games).
+		Msg("computed session summary")
+	return summary, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+)
+
+const (
+	skillTrendCollection = "skillTrends"
+	// trendGames is how many of the latest games the rolling averages cover
+	trendGames = 20
+	// trendDays is how far back the series goes
+	trendDays = 90
+	// changePointThreshold is the relative change in KDA between the games before and after that counts as a shift
+	changePointThreshold = 0.25
+	// changePointMinGames is how many games are needed on each side before a shift is trusted
+	changePointMinGames = 5
+
+	BuildChangePoint = "build"
+	ShiftChangePoint = "shift"
+)
+
+// trendAlpha weights the latest game the same as a trendGames game rolling average would on its own
+const trendAlpha = 2.0 / (trendGames + 1)
+
+// SkillTrend is a character's performance over time, one document per character for charting.
+type SkillTrend struct {
+	UserID      string    `firestore:"userId" json:"userId"`
+	CharacterID string    `firestore:"characterId" json:"characterId"`
+	ComputedAt  time.Time `firestore:"computedAt" json:"computedAt"`
+	From        time.Time `firestore:"from" json:"from"`
+	// Window Number of games the rolling averages cover
+	Window int `firestore:"window" json:"window"`
+	// Alpha Weight of the latest game in the exponential averages
+	Alpha float64 `firestore:"alpha" json:"alpha"`
+	// Points One per day played, oldest first
+	Points       []SkillTrendPoint  `firestore:"points" json:"points"`
+	ChangePoints []SkillChangePoint `firestore:"changePoints" json:"changePoints"`
+}
+
+// SkillTrendPoint is where the averages stood after the last game of the day.
+type SkillTrendPoint struct {
+	// Date Day in UTC as YYYY-MM-DD
+	Date    string       `firestore:"date" json:"date"`
+	Games   int          `firestore:"games" json:"games"`
+	Rolling SkillMetrics `firestore:"rolling" json:"rolling"`
+	EWMA    SkillMetrics `firestore:"ewma" json:"ewma"`
+}
+
+type SkillMetrics struct {
+	KD         float64 `firestore:"kd" json:"kd"`
+	KDA        float64 `firestore:"kda" json:"kda"`
+	Efficiency float64 `firestore:"efficiency" json:"efficiency"`
+	WinRate    float64 `firestore:"winRate" json:"winRate"`
+}
+
+// SkillChangePoint marks the game where performance shifted, comparing the KDA of the games before it to the games
+// from it on.
+type SkillChangePoint struct {
+	Date        string    `firestore:"date" json:"date"`
+	Period      time.Time `firestore:"period" json:"period"`
+	AggregateID string    `firestore:"aggregateId" json:"aggregateId"`
+	// Reason build when the game was the first in a new snapshot, shift otherwise
+	Reason     string  `firestore:"reason" json:"reason"`
+	SnapshotID *string `firestore:"snapshotId" json:"snapshotId,omitempty"`
+	BeforeKDA  float64 `firestore:"beforeKda" json:"beforeKda"`
+	AfterKDA   float64 `firestore:"afterKda" json:"afterKda"`
+	// Change Relative change from BeforeKDA to AfterKDA
+	Change float64 `firestore:"change" json:"change"`
+}
+
+// trendGame is the part of a game the trends are computed from.
+type trendGame struct {
+	AggregateID string
+	Period      time.Time
+	SnapshotID  *string
+	Kills       float64
+	Deaths      float64
+	Assists     float64
+	Win         float64
+}
+
+// skillMetrics derives the ratios from summed or averaged kills, deaths, assists and wins.
+func skillMetrics(kills, deaths, assists, wins, games float64) SkillMetrics {
+	m := SkillMetrics{
+		KD:         ratio(kills, deaths),
+		KDA:        ratio(kills+assists/2, deaths),
+		Efficiency: ratio(kills+assists, deaths),
+	}
+	if games > 0 {
+		m.WinRate = wins / games
+	}
+	return m
+}
+
+// sumMetrics is the ratios over the games.
+func sumMetrics(games []trendGame) SkillMetrics {
+	var kills, deaths, assists, wins float64
+	for _, game := range games {
+		kills += game.Kills
+		deaths += game.Deaths
+		assists += game.Assists
+		wins += game.Win
+	}
+	return skillMetrics(kills, deaths, assists, wins, float64(len(games)))
+}
+
+// trendPoints walks the games oldest first and keeps the last point of every day. The exponential averages are kept
+// for kills, deaths, assists and wins and the ratios derived from them, so a single deathless game can't spike them.
+func trendPoints(games []trendGame) []SkillTrendPoint {
+	points := make([]SkillTrendPoint, 0)
+	var kills, deaths, assists, wins float64
+	for i, game := range games {
+		if i == 0 {
+			kills, deaths, assists, wins = game.Kills, game.Deaths, game.Assists, game.Win
+		} else {
+			kills += trendAlpha * (game.Kills - kills)
+			deaths += trendAlpha * (game.Deaths - deaths)
+			assists += trendAlpha * (game.Assists - assists)
+			wins += trendAlpha * (game.Win - wins)
+		}
+		point := SkillTrendPoint{
+			Date:    game.Period.UTC().Format(time.DateOnly),
+			Games:   1,
+			Rolling: sumMetrics(games[max(0, i+1-trendGames) : i+1]),
+			EWMA:    skillMetrics(kills, deaths, assists, wins, 1),
+		}
+		if last := len(points) - 1; last >= 0 && points[last].Date == point.Date {
+			point.Games += points[last].Games
+			points[last] = point
+			continue
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// changeAt compares the KDA of up to trendGames games before the index to the games from it on. ok is false when
+// either side is too short to trust.
+func changeAt(games []trendGame, i int) (before, after, change float64, ok bool) {
+	if i < changePointMinGames || len(games)-i < changePointMinGames {
+		return 0, 0, 0, false
+	}
+	before = sumMetrics(games[max(0, i-trendGames):i]).KDA
+	after = sumMetrics(games[i:min(len(games), i+trendGames)]).KDA
+	if before == 0 {
+		return before, after, 0, false
+	}
+	return before, after, (after - before) / before, true
+}
+
+func newChangePoint(game trendGame, reason string, before, after, change float64) SkillChangePoint {
+	return SkillChangePoint{
+		Date:        game.Period.UTC().Format(time.DateOnly),
+		Period:      game.Period,
+		AggregateID: game.AggregateID,
+		Reason:      reason,
+		SnapshotID:  game.SnapshotID,
+		BeforeKDA:   before,
+		AfterKDA:    after,
+		Change:      change,
+	}
+}
+
+// trendChangePoints marks the games where the KDA shifted by changePointThreshold or more. Snapshot changes are
+// checked first so a shift that lines up with a new build is credited to it. The rest of the games are then scanned
+// away from those markers: the windows around a shift cross the threshold for several games in a row, so only the
+// game with the largest change in each run is marked.
+func trendChangePoints(games []trendGame) []SkillChangePoint {
+	points := make([]SkillChangePoint, 0)
+	marked := make([]int, 0)
+	near := func(i int) bool {
+		for _, m := range marked {
+			if i-m < trendGames && m-i < trendGames {
+				return true
+			}
+		}
+		return false
+	}
+
+	var previous *string
+	for i, game := range games {
+		if game.SnapshotID == nil {
+			continue
+		}
+		changed := previous != nil && *previous != *game.SnapshotID
+		previous = game.SnapshotID
+		if !changed {
+			continue
+		}
+		if before, after, change, ok := changeAt(games, i); ok && math.Abs(change) >= changePointThreshold {
+			points = append(points, newChangePoint(game, BuildChangePoint, before, after, change))
+			marked = append(marked, i)
+		}
+	}
+
+	var best *SkillChangePoint
+	for i, game := range games {
+		before, after, change, ok := changeAt(games, i)
+		if near(i) || !ok || math.Abs(change) < changePointThreshold {
+			if best != nil {
+				points = append(points, *best)
+				best = nil
+			}
+			continue
+		}
+		if best == nil || math.Abs(change) > math.Abs(best.Change) {
+			point := newChangePoint(game, ShiftChangePoint, before, after, change)
+			best = &point
+		}
+	}
+	if best != nil {
+		points = append(points, *best)
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Period.Before(points[j].Period)
+	})
+	return points
+}
+
+// ComputeSkillTrend builds the character's series from their games over the last trendDays days.
+func ComputeSkillTrend(userID, characterID string, source *rollupSource) *SkillTrend {
+	from := source.Now.AddDate(0, 0, -trendDays)
+	aggregates := source.Aggregates[characterID]
+	games := make([]trendGame, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		if aggregate.ActivityDetails.Period.Before(from) {
+			continue
+		}
+		performance, ok := aggregate.Performance[characterID]
+		if !ok {
+			continue
+		}
+		stats := performance.PlayerStats
+		game := trendGame{
+			AggregateID: aggregate.ID,
+			Period:      aggregate.ActivityDetails.Period,
+			Kills:       statValue(stats.Kills),
+			Deaths:      statValue(stats.Deaths),
+			Assists:     statValue(stats.Assists),
+		}
+		if isWin(stats) {
+			game.Win = 1
+		}
+		if link := LookupLink(&aggregate, characterID); link != nil {
+			game.SnapshotID = link.SnapshotID
+		}
+		games = append(games, game)
+	}
+	return &SkillTrend{
+		UserID:       userID,
+		CharacterID:  characterID,
+		ComputedAt:   source.Now,
+		From:         from,
+		Window:       trendGames,
+		Alpha:        trendAlpha,
+		Points:       trendPoints(games),
+		ChangePoints: trendChangePoints(games),
+	}
+}
+
+// RollupSkillTrends recomputes and stores the skill trend of every character of the user.
+func RollupSkillTrends(ctx context.Context, db *firestore.Client, w Writer, user User, source *rollupSource) error {
+	for _, characterID := range user.CharacterIDs {
+		trend := ComputeSkillTrend(user.ID, characterID, source)
+		if err := w.Set(ctx, db.Collection(skillTrendCollection).Doc(characterID), trend); err != nil {
+			return fmt.Errorf("failed to save skill trend: %w", err)
+		}
+		zerolog.Ctx(ctx).Info().
+			Str("userId", user.ID).
+			Str("characterId", characterID).
+			Int("points", len(trend.Points)).
+			Int("changePoints", len(trend.ChangePoints)).
+			Msg("rolled up skill trend")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// trendGamesFrom builds one game per KDA, an hour apart. Each game has the KDA as kills and a single death.
+func trendGamesFrom(snapshots []string, kdas ...float64) []trendGame {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	games := make([]trendGame, 0, len(kdas))
+	for i, kda := range kdas {
+		game := trendGame{
+			AggregateID: "agg-" + strconv.Itoa(i),
+			Period:      start.Add(time.Duration(i) * time.Hour),
+			Kills:       kda,
+			Deaths:      1,
+		}
+		if snapshots != nil {
+			game.SnapshotID = &snapshots[i]
+		}
+		games = append(games, game)
+	}
+	return games
+}
+
+func repeat(value float64, n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = value
+	}
+	return values
+}
+
+func repeatString(value string, n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = value
+	}
+	return values
+}
+
+func TestTrendChangePoints(t *testing.T) {
+	type want struct {
+		index  int
+		reason string
+	}
+	tests := []struct {
+		name      string
+		kdas      []float64
+		snapshots []string
+		want      []want
+	}{
+		{
+			name: "steady performance has no change points",
+			kdas: repeat(1, 30),
+			want: []want{},
+		},
+		{
+			name: "too few games on either side",
+			kdas: append(repeat(1, changePointMinGames-1), repeat(3, changePointMinGames-1)...),
+			want: []want{},
+		},
+		{
+			name: "a shift is marked once at the largest change",
+			kdas: append(repeat(1, 10), repeat(2, 10)...),
+			want: []want{{index: 10, reason: ShiftChangePoint}},
+		},
+		{
+			name: "changes under the threshold are ignored",
+			kdas: append(repeat(1, 10), repeat(1.2, 10)...),
+			want: []want{},
+		},
+		{
+			name:      "a shift on a new snapshot is credited to the build",
+			kdas:      append(repeat(1, 10), repeat(2, 10)...),
+			snapshots: append(repeatString("a", 10), repeatString("b", 10)...),
+			want:      []want{{index: 10, reason: BuildChangePoint}},
+		},
+		{
+			name:      "a new snapshot without a shift isn't marked",
+			kdas:      repeat(1, 20),
+			snapshots: append(repeatString("a", 10), repeatString("b", 10)...),
+			want:      []want{},
+		},
+		{
+			name: "shifts far apart are marked separately",
+			kdas: append(append(repeat(1, 25), repeat(3, 25)...), repeat(1, 25)...),
+			want: []want{{index: 25, reason: ShiftChangePoint}, {index: 50, reason: ShiftChangePoint}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			games := trendGamesFrom(tt.snapshots, tt.kdas...)
+			points := trendChangePoints(games)
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %d change points, want %d: %+v", len(points), len(tt.want), points)
+			}
+			for i, want := range tt.want {
+				point := points[i]
+				if !point.Period.Equal(games[want.index].Period) {
+					t.Errorf("change point %d at %s, want game %d at %s", i, point.Period, want.index, games[want.index].Period)
+				}
+				if point.Reason != want.reason {
+					t.Errorf("change point %d reason = %s, want %s", i, point.Reason, want.reason)
+				}
+				if point.AggregateID != games[want.index].AggregateID {
+					t.Errorf("change point %d aggregate = %s, want %s", i, point.AggregateID, games[want.index].AggregateID)
+				}
+			}
+		})
+	}
+}
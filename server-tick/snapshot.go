@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"log/slog"
 	"serverTick/bungie"
 	"serverTick/generator"
 	"serverTick/utils"
@@ -12,7 +11,7 @@ import (
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 const (
@@ -20,34 +19,38 @@ const (
 	historyCollection  = "histories"
 )
 
-func Save(ctx context.Context, db *firestore.Client, client *bungie.ClientWithResponses, userID, membershipID, characterID string) (*CharacterSnapshot, error) {
+// Save snapshots the character's current loadout. The returned bool is true when the loadout hadn't been seen before
+// and a new snapshot was created for it.
+func Save(ctx context.Context, db *firestore.Client, w Writer, client *bungie.ClientWithResponses, userID, membershipID, characterID string) (*CharacterSnapshot, bool, error) {
 	data, err := generateSnapshot(ctx, db, client, userID, membershipID, characterID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build data: %v", err)
+		return nil, false, fmt.Errorf("failed to build data: %v", err)
 	}
 	if data == nil {
-		return nil, fmt.Errorf("failed to generate snapshot")
+		return nil, false, fmt.Errorf("failed to generate snapshot")
 	}
-	id, err := create(ctx, db, userID, *data)
+	id, name, created, err := create(ctx, db, w, userID, *data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+		return nil, false, fmt.Errorf("failed to create snapshot: %w", err)
 	}
 	data.ID = *id
-	return data, nil
+	data.Name = name
+	return data, created, nil
 }
 func generateSnapshot(ctx context.Context, db *firestore.Client, client *bungie.ClientWithResponses, userID, membershipID, characterID string) (*CharacterSnapshot, error) {
 
-	membershipType, _, err := GetMembershipType(ctx, db, userID)
+	user, err := GetUser(ctx, db, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch membership type: %w", err)
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
 	}
+	membershipType := user.MembershipType()
 
 	memID, err := strconv.ParseInt(membershipID, 10, 64)
 	if err != nil {
 		return nil, fmt.Errorf("invalid membership id: %w", err)
 	}
 
-	loadout, stats, timestamp, err := GetLoadout(ctx, db, client, memID, membershipType, characterID)
+	loadout, stats, timestamp, err := GetLoadout(ctx, db, client, memID, membershipType, characterID, user.Locale)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch profile data: %w", err)
 	}
@@ -98,24 +101,26 @@ func generateHash(snapshot CharacterSnapshot) (string, error) {
 	return hash, nil
 }
 
-func create(ctx context.Context, db *firestore.Client, userID string, snapshot CharacterSnapshot) (*string, error) {
+func create(ctx context.Context, db *firestore.Client, w Writer, userID string, snapshot CharacterSnapshot) (*string, string, bool, error) {
 
 	if snapshot.Hash == "" {
 		// Instance has of each item in the Loadout
 		hash, err := generateHash(snapshot)
 		if err != nil {
-			return nil, err
+			return nil, "", false, err
 		}
 		snapshot.Hash = hash
 	}
 
+	l := zerolog.Ctx(ctx)
 	existingSnapshot, err := optionalGetByHash(db, ctx, snapshot.Hash)
 	if err != nil {
-		return nil, err
+		return nil, "", false, err
 	}
 	if existingSnapshot != nil {
-		log.Info().Msg("Creating a history entry")
-		return createHistoryEntry(ctx, db, *existingSnapshot)
+		l.Info().Msg("Creating a history entry")
+		id, err := createHistoryEntry(ctx, db, w, *existingSnapshot)
+		return id, existingSnapshot.Name, false, err
 	}
 
 	snapshot.UserID = userID
@@ -127,13 +132,14 @@ func create(ctx context.Context, db *firestore.Client, userID string, snapshot C
 	}
 	ref := db.Collection(snapshotCollection).NewDoc()
 	snapshot.ID = ref.ID
-	_, err = ref.Set(ctx, snapshot)
-	log.Info().Msg("Created original snapshot")
+	err = w.Set(ctx, ref, snapshot)
 	if err != nil {
-		return nil, err
+		return nil, "", false, err
 	}
-	log.Info().Msg("Creating a history entry for original snapshot")
-	return createHistoryEntry(ctx, db, snapshot)
+	l.Info().Str("snapshotId", snapshot.ID).Msg("Created original snapshot")
+	l.Info().Msg("Creating a history entry for original snapshot")
+	id, err := createHistoryEntry(ctx, db, w, snapshot)
+	return id, snapshot.Name, true, err
 }
 
 func optionalGetByHash(db *firestore.Client, ctx context.Context, hash string) (*CharacterSnapshot, error) {
@@ -152,7 +158,7 @@ func optionalGetByHash(db *firestore.Client, ctx context.Context, hash string) (
 	return &og, nil
 }
 
-func createHistoryEntry(ctx context.Context, db *firestore.Client, og CharacterSnapshot) (*string, error) {
+func createHistoryEntry(ctx context.Context, db *firestore.Client, w Writer, og CharacterSnapshot) (*string, error) {
 	now := time.Now()
 	history := History{
 		ParentID:    og.ID,
@@ -167,12 +173,12 @@ func createHistoryEntry(ctx context.Context, db *firestore.Client, og CharacterS
 	}
 	ref := db.Collection(snapshotCollection).Doc(og.ID).Collection(historyCollection).NewDoc()
 	history.ID = ref.ID
-	_, err := ref.Set(ctx, history)
+	err := w.Set(ctx, ref, history)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Collection(snapshotCollection).Doc(og.ID).Set(ctx, map[string]interface{}{
+	err = w.Set(ctx, db.Collection(snapshotCollection).Doc(og.ID), map[string]interface{}{
 		"updatedAt": now,
 	}, firestore.MergeAll)
 	if err != nil {
@@ -186,13 +192,11 @@ func FindBestFit(ctx context.Context, db *firestore.Client, userID string, chara
 	minTime := activityPeriod.Add(time.Duration(-12) * time.Hour)
 	// A game can last about 8 minutes over the starting time
 	maxTime := activityPeriod.Add(time.Duration(15) * time.Minute)
-	l := slog.With(
-		"activityPeriod", activityPeriod,
-		"minTime", minTime,
-		"maxTime", maxTime,
-		"userId", userID,
-		"characterId", characterID,
-	)
+	l := zerolog.Ctx(ctx).With().
+		Time("activityPeriod", activityPeriod).
+		Time("minTime", minTime).
+		Time("maxTime", maxTime).
+		Logger()
 	docs, err := db.CollectionGroup(historyCollection).
 		Where("userId", "==", userID).
 		Where("characterId", "==", characterID).
@@ -201,7 +205,7 @@ func FindBestFit(ctx context.Context, db *firestore.Client, userID string, chara
 		OrderBy("timestamp", firestore.Desc).
 		Documents(ctx).GetAll()
 	if err != nil {
-		l.Error("failed to get histories", "error", err.Error())
+		l.Error().Err(err).Msg("failed to get histories")
 		return nil, nil, err
 	}
 
@@ -221,7 +225,7 @@ func FindBestFit(ctx context.Context, db *firestore.Client, userID string, chara
 	)
 	histories, err := utils.GetAllToStructs[History](docs)
 	if err != nil {
-		l.Error("failed to get all histories", "error", err.Error())
+		l.Error().Err(err).Msg("failed to get all histories")
 		return nil, nil, err
 	}
 
@@ -280,7 +284,7 @@ func FindBestFit(ctx context.Context, db *firestore.Client, userID string, chara
 
 	snap, err := Get(ctx, db, bestFit.ParentID)
 	if err != nil {
-		l.Error("failed to get snapshot", "error", err.Error())
+		l.Error().Err(err).Msg("failed to get snapshot")
 		return nil, nil, err
 	}
 	return snap, &link, nil
@@ -295,26 +299,28 @@ func Get(ctx context.Context, db *firestore.Client, snapshotID string) (*Charact
 	if err != nil {
 		return nil, err
 	}
+	result.finishPerformance()
 	return result, nil
 }
 
-func EnrichInstancePerformance(snapshot *CharacterSnapshot, performance InstancePerformance) (*InstancePerformance, error) {
+func EnrichInstancePerformance(ctx context.Context, snapshot *CharacterSnapshot, performance InstancePerformance) (*InstancePerformance, error) {
+	l := zerolog.Ctx(ctx)
 	result := &InstancePerformance{
 		Extra:       performance.Extra,
 		PlayerStats: performance.PlayerStats,
 		Weapons:     performance.Weapons,
 	}
 	if snapshot == nil {
-		log.Debug().Msg("No provided snapshot to perform enrichment on")
+		l.Debug().Msg("No provided snapshot to perform enrichment on")
 		return result, nil
 	}
 
 	if len(performance.Weapons) == 0 {
-		log.Debug().Msg("No metrics provided to enrich")
+		l.Debug().Msg("No metrics provided to enrich")
 		return result, nil
 	}
 	if snapshot.Loadout == nil {
-		log.Debug().Msg("No loadout provided to enrich")
+		l.Debug().Msg("No loadout provided to enrich")
 		return result, nil
 	}
 
@@ -362,6 +368,9 @@ type CharacterSnapshot struct {
 	Name  string                `firestore:"name" json:"name"`
 	Stats *map[string]ClassStat `firestore:"stats" json:"stats,omitempty"`
 
+	// Performance How the build performed in linked games, keyed by confidence level
+	Performance map[string]SnapshotPerformance `firestore:"performance,omitempty" json:"performance,omitempty"`
+
 	// UpdatedAt Timestamp for when the snapshot was last updated or when a history entry was made for it.
 	UpdatedAt time.Time `firestore:"updatedAt" json:"updatedAt"`
 
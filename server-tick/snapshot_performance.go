@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+)
+
+const (
+	snapshotPerformanceField = "performance"
+
+	KineticSlot = "kinetic"
+	EnergySlot  = "energy"
+	PowerSlot   = "power"
+	// OtherSlot counts kills with weapons that weren't in the snapshot's loadout
+	OtherSlot = "other"
+)
+
+// SnapshotPerformance is how a build performed in the games linked to it, counted separately for every
+// confidence level so the app can decide how sure a link has to be before it counts. Only the totals are stored,
+// since they are kept with increments; the ratios are derived when the snapshot is read.
+type SnapshotPerformance struct {
+	Games   int64   `firestore:"games" json:"games"`
+	Wins    int64   `firestore:"wins" json:"wins"`
+	Kills   float64 `firestore:"kills" json:"kills"`
+	Deaths  float64 `firestore:"deaths" json:"deaths"`
+	Assists float64 `firestore:"assists" json:"assists"`
+	// SlotKills Kills by weapon slot: kinetic, energy, power or other
+	SlotKills map[string]int64 `firestore:"slotKills" json:"slotKills"`
+	WinRate   float64          `firestore:"-" json:"winRate"`
+	KD        float64          `firestore:"-" json:"kd"`
+	KDA       float64          `firestore:"-" json:"kda"`
+}
+
+// add counts a single game.
+func (p *SnapshotPerformance) add(performance InstancePerformance) {
+	stats := performance.PlayerStats
+	p.Games++
+	if isWin(stats) {
+		p.Wins++
+	}
+	p.Kills += statValue(stats.Kills)
+	p.Deaths += statValue(stats.Deaths)
+	p.Assists += statValue(stats.Assists)
+	if p.SlotKills == nil {
+		p.SlotKills = make(map[string]int64)
+	}
+	for _, metrics := range performance.Weapons {
+		p.SlotKills[weaponSlot(metrics)] += int64(uniqueStatValue(metrics.Stats, "uniqueWeaponKills"))
+	}
+}
+
+// finish derives the ratios from the totals.
+func (p *SnapshotPerformance) finish() {
+	if p.Games > 0 {
+		p.WinRate = float64(p.Wins) / float64(p.Games)
+	}
+	p.KD = ratio(p.Kills, p.Deaths)
+	p.KDA = ratio(p.Kills+p.Assists/2, p.Deaths)
+}
+
+// finishPerformance derives the ratios of every confidence level of the snapshot.
+func (s *CharacterSnapshot) finishPerformance() {
+	for level, p := range s.Performance {
+		p.finish()
+		s.Performance[level] = p
+	}
+}
+
+// countsConfidence reports whether games linked with the level count towards a snapshot's performance.
+func countsConfidence(level ConfidenceLevel) bool {
+	switch level {
+	case HighConfidenceLevel, MediumConfidenceLevel, LowConfidenceLevel:
+		return true
+	}
+	return false
+}
+
+func weaponSlot(metrics WeaponInstanceMetrics) string {
+	if metrics.ItemProperties == nil {
+		return OtherSlot
+	}
+	switch WeaponBucket(metrics.ItemProperties.BaseInfo.BucketHash) {
+	case KineticBucket:
+		return KineticSlot
+	case EnergyBucket:
+		return EnergySlot
+	case PowerBucket:
+		return PowerSlot
+	}
+	return OtherSlot
+}
+
+// snapshotPerformanceIncrements are the increments that add a game to, or with sign -1 remove it from, a snapshot.
+func snapshotPerformanceIncrements(performance InstancePerformance, sign int64) map[string]any {
+	var game SnapshotPerformance
+	game.add(performance)
+	slots := make(map[string]any, len(game.SlotKills))
+	for slot, kills := range game.SlotKills {
+		slots[slot] = Increment(sign * kills)
+	}
+	return map[string]any{
+		"games":     Increment(sign * game.Games),
+		"wins":      Increment(sign * game.Wins),
+		"kills":     Increment(float64(sign) * game.Kills),
+		"deaths":    Increment(float64(sign) * game.Deaths),
+		"assists":   Increment(float64(sign) * game.Assists),
+		"slotKills": slots,
+	}
+}
+
+// incrementSnapshotPerformance adds or removes a game from the snapshot's totals for the confidence level. It is a
+// single write of increments, so it is atomic and a dry run records exactly what would be applied.
+func incrementSnapshotPerformance(ctx context.Context, db *firestore.Client, w Writer, snapshotID string, level ConfidenceLevel, performance InstancePerformance, sign int64) error {
+	ref := db.Collection(snapshotCollection).Doc(snapshotID)
+	return w.Set(ctx, ref, map[string]any{
+		snapshotPerformanceField: map[string]any{
+			string(level): snapshotPerformanceIncrements(performance, sign),
+		},
+	}, firestore.MergeAll)
+}
+
+// UpdateSnapshotPerformance moves a game between snapshots when the character's link for it changes. A game that
+// was already linked to the same snapshot with the same confidence is left alone, so ticking an activity again
+// doesn't count it twice.
+func UpdateSnapshotPerformance(ctx context.Context, db *firestore.Client, w Writer, previous *SnapshotLink, previousPerformance *InstancePerformance, link SnapshotLink, performance InstancePerformance) error {
+	if previous != nil && sameSnapshotLink(*previous, link) {
+		return nil
+	}
+	l := zerolog.Ctx(ctx)
+	if previous != nil && previous.SnapshotID != nil && previousPerformance != nil && countsConfidence(previous.ConfidenceLevel) {
+		if err := incrementSnapshotPerformance(ctx, db, w, *previous.SnapshotID, previous.ConfidenceLevel, *previousPerformance, -1); err != nil {
+			return fmt.Errorf("failed to remove game from snapshot %s: %w", *previous.SnapshotID, err)
+		}
+		l.Debug().Str("snapshotId", *previous.SnapshotID).Msg("removed game from previous snapshot performance")
+	}
+	if link.SnapshotID != nil && countsConfidence(link.ConfidenceLevel) {
+		if err := incrementSnapshotPerformance(ctx, db, w, *link.SnapshotID, link.ConfidenceLevel, performance, 1); err != nil {
+			return fmt.Errorf("failed to add game to snapshot %s: %w", *link.SnapshotID, err)
+		}
+		l.Debug().Str("snapshotId", *link.SnapshotID).Msg("added game to snapshot performance")
+	}
+	return nil
+}
+
+func sameSnapshotLink(a, b SnapshotLink) bool {
+	if a.ConfidenceLevel != b.ConfidenceLevel {
+		return false
+	}
+	if a.SnapshotID == nil || b.SnapshotID == nil {
+		return a.SnapshotID == b.SnapshotID
+	}
+	return *a.SnapshotID == *b.SnapshotID
+}
+
+// RebuildSnapshotPerformance recomputes the performance of every snapshot of the user from the games linked to them,
+// for games linked before snapshots kept one. The stored totals are replaced, so it is safe to run again. It returns
+// the number of snapshots updated.
+func RebuildSnapshotPerformance(ctx context.Context, db *firestore.Client, w Writer, user User) (int, error) {
+	l := zerolog.Ctx(ctx)
+	totals := make(map[string]map[string]SnapshotPerformance)
+	for _, characterID := range user.CharacterIDs {
+		aggregates, err := GetCharacterAggregates(ctx, db, characterID, time.Time{})
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch aggregates for character %s: %w", characterID, err)
+		}
+		for _, aggregate := range aggregates {
+			link := LookupLink(&aggregate, characterID)
+			if link == nil || link.SnapshotID == nil || !countsConfidence(link.ConfidenceLevel) {
+				continue
+			}
+			performance, ok := aggregate.Performance[characterID]
+			if !ok {
+				continue
+			}
+			byLevel, ok := totals[*link.SnapshotID]
+			if !ok {
+				byLevel = make(map[string]SnapshotPerformance)
+				totals[*link.SnapshotID] = byLevel
+			}
+			p := byLevel[string(link.ConfidenceLevel)]
+			p.add(performance)
+			byLevel[string(link.ConfidenceLevel)] = p
+		}
+	}
+
+	// Only the IDs are needed, so the loadouts aren't read
+	docs, err := db.Collection(snapshotCollection).Where("userId", "==", user.ID).Select().Documents(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch snapshots: %w", err)
+	}
+	for _, doc := range docs {
+		performance := totals[doc.Ref.ID]
+		if performance == nil {
+			performance = make(map[string]SnapshotPerformance)
+		}
+		err := w.Update(ctx, doc.Ref, []firestore.Update{{Path: snapshotPerformanceField, Value: performance}})
+		if err != nil {
+			return 0, fmt.Errorf("failed to save performance of snapshot %s: %w", doc.Ref.ID, err)
+		}
+		l.Debug().Str("snapshotId", doc.Ref.ID).Int("levels", len(performance)).Msg("rebuilt snapshot performance")
+	}
+	return len(docs), nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"serverTick/bungie"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+)
+
+// ProcessSession runs a single server tick for the session: saving the current loadout, pulling the latest
+// PvP games and linking any new ones to the session as aggregates. Stale or inactive sessions are ended. It returns
+// how many games were linked, so callers only recompute rollups for users with new games.
+func ProcessSession(ctx context.Context, db *firestore.Client, w Writer, cli *bungie.ClientWithResponses, cache PGCRCache, hooks *Webhooks, config Config, session Session) (int, error) {
+	ctx = withSessionLogger(ctx, session)
+	l := zerolog.Ctx(ctx)
+
+	membershipType, membershipID, err := GetMembershipType(ctx, db, session.UserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch membership type: %w", err)
+	}
+
+	if session.TrackedAt == nil {
+		if err := MarkSessionTracked(ctx, db, w, hooks, session); err != nil {
+			l.Warn().Err(err).Msg("failed to mark session tracked. Continuing on")
+		}
+	}
+
+	// This could be moved to something else in the future maybe. It's not super necessary
+	// that it is done here before the rest of the logic. Just that it is done
+	if !config.SkipSave {
+		l.Info().Msg("starting to save loadout")
+		startTime := time.Now()
+		snapshot, created, err := Save(ctx, db, w, cli, session.UserID, membershipID, session.CharacterID)
+		if err != nil {
+			// Usually Bungie being unavailable, the next tick tries again
+			l.Warn().Err(err).Msg("[SKIP]: failed to save loadout")
+			return 0, nil
+		}
+		if created {
+			hooks.Send(ctx, db, w, session, SnapshotCreatedEvent, SnapshotCreatedData{SnapshotID: snapshot.ID, Name: snapshot.Name})
+		}
+		l.Info().
+			TimeDiff("loadoutDuration", time.Now(), startTime).
+			Msg("saved loadout")
+
+	}
+	l.Info().Msg("starting to get pvp games")
+	startTime := time.Now()
+	// Activity history should be shared
+	activityHistories, err := GetAllPVP(
+		ctx,
+		cli,
+		db,
+		membershipID,
+		membershipType,
+		session.CharacterID,
+		2,
+		0,
+	)
+	if err != nil {
+		l.Warn().Err(err).Msg("[SKIP]: failed to get activities")
+		return 0, nil
+	}
+	l.Info().
+		TimeDiff("pvpDuration", time.Now(), startTime).
+		Msg("got pvp response")
+
+	if len(activityHistories) == 0 {
+		l.Warn().Msg("[SKIP]: no history found for user")
+		return 0, nil
+	}
+
+	latest := activityHistories[0]
+
+	if session.LastSeenActivityID != nil && *session.LastSeenActivityID == latest.InstanceID {
+		l.Info().Msg("[SKIP]: No new activities since last check-in")
+		if IsStaleSession(session, latest) {
+			err := EndSession(ctx, db, w, hooks, session, StaleSessionReason)
+			if err != nil {
+				return 0, err
+			}
+			l.Info().Msg("session is stale. Ending session")
+		}
+		return 0, nil
+	}
+
+	IDs := make([]string, 0)
+	histories := make([]ActivityHistory, 0)
+	// Only choose activities that happened after starting the session
+	gracePeriod := session.StartedAt.Add(-15 * time.Minute)
+	for _, activity := range activityHistories {
+		if activity.Period.After(gracePeriod) {
+			IDs = append(IDs, activity.InstanceID)
+			histories = append(histories, activity)
+		}
+	}
+
+	if len(IDs) == 0 {
+		l.Info().Msg("[SKIP]: No new activity to save. Checking if Inactive")
+		if IsInactiveSession(session) {
+			err := EndSession(ctx, db, w, hooks, session, InactiveSessionReason)
+			if err != nil {
+				return 0, err
+			}
+			l.Info().Msg("session is inactive. Ending session")
+		}
+		return 0, nil
+	}
+
+	l.Info().Strs("IDs", IDs).Msg("Activities Found")
+
+	existingAggs, err := GetAggregatesByActivity(ctx, db, IDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch aggregates by the provided IDs: %w", err)
+	}
+
+	l.Info().Msgf("Length of existing Aggs: %d", len(existingAggs))
+
+	existingAggMap := make(map[string]*Aggregate)
+	for _, agg := range existingAggs {
+		existingAggMap[agg.ActivityID] = &agg
+	}
+
+	aggIDs := make([]string, 0)
+	// TODO: Maybe this should be after total success at the end of the loop
+	err = SetLastActivity(ctx, db, w, session.ID, latest.InstanceID)
+	if err != nil {
+		l.Warn().Err(err).Msg("failed to save last activity for session. Continuing on")
+	}
+	for _, history := range histories {
+		ctx := withActivityLogger(ctx, history.InstanceID)
+		al := zerolog.Ctx(ctx)
+		agg := existingAggMap[history.InstanceID]
+
+		link := LookupLink(agg, session.CharacterID)
+		// Already attempted to link this character to this activity so we can skip it
+		if link != nil && link.SessionID != nil {
+			al.Info().Msg("Already linked to this activity")
+			continue
+		}
+
+		performances, err := GetPerformances(ctx, cli, cache, db, history.InstanceID, session.CharacterID)
+		if err != nil {
+			al.Error().Err(err).Msg("failed to fetch performances")
+			continue
+		}
+		performance, ok := performances[session.CharacterID]
+		if !ok {
+			al.Warn().Msg("no performance found for member")
+			continue
+		}
+		a, err := SetAggregate(
+			ctx,
+			db,
+			w,
+			session.UserID,
+			session.CharacterID,
+			history,
+			history.Period,
+			performance,
+			&session.ID,
+		)
+		if err != nil {
+			al.Error().Err(err).Msg("failed to add data to aggregate")
+			continue
+		}
+		aggIDs = append(aggIDs, a.ID)
+		// The stored performance has been enriched with weapon names and icons
+		if enriched, ok := a.Performance[session.CharacterID]; ok {
+			performance = enriched
+		}
+		hooks.Send(ctx, db, w, session, MatchRecordedEvent, NewMatchRecordedData(ctx, db, *a, session.CharacterID, performance))
+	}
+	l.Info().Strs("aggregateIds", aggIDs).Msgf("Aggregates to add")
+
+	err = AddAggregateIDs(ctx, db, w, session.ID, aggIDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add aggregate IDs to session: %w", err)
+	}
+	l.Info().Strs("aggregates", aggIDs).Msg("Added aggregate IDs to session")
+	return len(aggIDs), nil
+}
@@ -4,14 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log/slog"
+	"maps"
 	"serverTick/bungie"
 	"serverTick/utils"
+	"slices"
 	"strconv"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 var ErrDestinyServerDown = errors.New("destiny server is down")
@@ -27,7 +28,6 @@ type Manifest struct {
 	InventoryBucketDefinition                map[string]InventoryBucketDefinition `json:"DestinyInventoryBucketDefinition"`
 	RaceDefinition                           map[string]RaceDefinition            `json:"DestinyRaceDefinition"`
 	UnlockDefinition                         map[string]any                       `json:"DestinyUnlockDefinition"`
-	StatGroupDefinition                      map[string]any                       `json:"DestinyStatGroupDefinition"`
 	ProgressionMappingDefinition             map[string]any                       `json:"DestinyProgressionMappingDefinition"`
 	FactionDefinition                        map[string]any                       `json:"DestinyFactionDefinition"`
 	VendorGroupDefinition                    map[string]any                       `json:"DestinyVendorGroupDefinition"`
@@ -70,7 +70,7 @@ type Manifest struct {
 	ObjectiveDefinition                      map[string]any                       `json:"DestinyObjectiveDefinition"`
 	SandboxPerkDefinition                    map[string]PerkDefinition            `json:"DestinySandboxPerkDefinition"`
 	PlatformBucketMappingDefinition          map[string]any                       `json:"DestinyPlatformBucketMappingDefinition"`
-	PlugSetDefinition                        map[string]any                       `json:"DestinyPlugSetDefinition"`
+	PlugSetDefinition                        map[string]PlugSetDefinition         `json:"DestinyPlugSetDefinition"`
 	PowerCapDefinition                       map[string]any                       `json:"DestinyPowerCapDefinition"`
 	PresentationNodeDefinition               map[string]any                       `json:"DestinyPresentationNodeDefinition"`
 	ProgressionDefinition                    map[string]any                       `json:"DestinyProgressionDefinition"`
@@ -81,7 +81,7 @@ type Manifest struct {
 	RewardItemListDefinition                 map[string]any                       `json:"DestinyRewardItemListDefinition"`
 	SackRewardItemListDefinition             map[string]any                       `json:"DestinySackRewardItemListDefinition"`
 	SandboxPatternDefinition                 map[string]any                       `json:"DestinySandboxPatternDefinition"`
-	SeasonDefinition                         map[string]any                       `json:"DestinySeasonDefinition"`
+	SeasonDefinition                         map[string]SeasonDefinition          `json:"DestinySeasonDefinition"`
 	SeasonPassDefinition                     map[string]any                       `json:"DestinySeasonPassDefinition"`
 	SocialCommendationDefinition             map[string]any                       `json:"DestinySocialCommendationDefinition"`
 	SocketCategoryDefinition                 map[string]any                       `json:"DestinySocketCategoryDefinition"`
@@ -213,6 +213,17 @@ type ItemDefinition struct {
 	TraitHashes                []int64               `json:"traitHashes" firestore:"traitHashes"`
 	Redacted                   bool                  `json:"redacted" firestore:"redacted"`
 	Blacklisted                bool                  `json:"blacklisted" firestore:"blacklisted"`
+	Sockets                    *ItemSockets          `json:"sockets" firestore:"sockets"`
+	SeasonHash                 int64                 `json:"seasonHash" firestore:"seasonHash"`
+	CollectibleHash            int64                 `json:"collectibleHash" firestore:"collectibleHash"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
+}
+
+// LocalizedDisplayProperties is the translated name and description of a definition.
+type LocalizedDisplayProperties struct {
+	Name        string `json:"name" firestore:"name"`
+	Description string `json:"description" firestore:"description"`
 }
 
 type ItemDisplayProperties struct {
@@ -268,6 +279,71 @@ type InvestmentStat struct {
 	Value                 int   `json:"value" firestore:"value"`
 	IsConditionallyActive bool  `json:"isConditionallyActive" firestore:"isConditionallyActive"`
 }
+type ItemSockets struct {
+	SocketEntries    []SocketEntry     `json:"socketEntries" firestore:"socketEntries"`
+	IntrinsicSockets []IntrinsicSocket `json:"intrinsicSockets" firestore:"intrinsicSockets"`
+	SocketCategories []ItemSocketGroup `json:"socketCategories" firestore:"socketCategories"`
+}
+
+type SocketEntry struct {
+	SocketTypeHash        int64 `json:"socketTypeHash" firestore:"socketTypeHash"`
+	SingleInitialItemHash int64 `json:"singleInitialItemHash" firestore:"singleInitialItemHash"`
+	ReusablePlugSetHash   int64 `json:"reusablePlugSetHash" firestore:"reusablePlugSetHash"`
+	RandomizedPlugSetHash int64 `json:"randomizedPlugSetHash" firestore:"randomizedPlugSetHash"`
+	DefaultVisible        bool  `json:"defaultVisible" firestore:"defaultVisible"`
+	PlugSources           int   `json:"plugSources" firestore:"plugSources"`
+}
+
+type IntrinsicSocket struct {
+	PlugItemHash   int64 `json:"plugItemHash" firestore:"plugItemHash"`
+	SocketTypeHash int64 `json:"socketTypeHash" firestore:"socketTypeHash"`
+	DefaultVisible bool  `json:"defaultVisible" firestore:"defaultVisible"`
+}
+
+// ItemSocketGroup lists the indexes into SocketEntries that belong to a socket category, e.g. the weapon perks.
+type ItemSocketGroup struct {
+	SocketCategoryHash int64 `json:"socketCategoryHash" firestore:"socketCategoryHash"`
+	SocketIndexes      []int `json:"socketIndexes" firestore:"socketIndexes"`
+}
+
+type PlugSetDefinition struct {
+	Hash              int64                 `json:"hash" firestore:"hash"`
+	Index             int                   `json:"index" firestore:"index"`
+	DisplayProperties ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	ReusablePlugItems []PlugSetItem         `json:"reusablePlugItems" firestore:"reusablePlugItems"`
+	IsFakePlugSet     bool                  `json:"isFakePlugSet" firestore:"isFakePlugSet"`
+	Redacted          bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted       bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+type PlugSetItem struct {
+	PlugItemHash     int64   `json:"plugItemHash" firestore:"plugItemHash"`
+	CurrentlyCanRoll bool    `json:"currentlyCanRoll" firestore:"currentlyCanRoll"`
+	Weight           float64 `json:"weight" firestore:"weight"`
+	AlternateWeight  float64 `json:"alternateWeight" firestore:"alternateWeight"`
+}
+
+type SeasonDefinition struct {
+	Hash                     int64                 `json:"hash" firestore:"hash"`
+	Index                    int                   `json:"index" firestore:"index"`
+	DisplayProperties        ItemDisplayProperties `json:"displayProperties" firestore:"displayProperties"`
+	BackgroundImagePath      string                `json:"backgroundImagePath" firestore:"backgroundImagePath"`
+	SeasonNumber             int                   `json:"seasonNumber" firestore:"seasonNumber"`
+	StartDate                string                `json:"startDate" firestore:"startDate"`
+	EndDate                  string                `json:"endDate" firestore:"endDate"`
+	SeasonPassHash           int64                 `json:"seasonPassHash" firestore:"seasonPassHash"`
+	ArtifactItemHash         int64                 `json:"artifactItemHash" firestore:"artifactItemHash"`
+	SealPresentationNodeHash int64                 `json:"sealPresentationNodeHash" firestore:"sealPresentationNodeHash"`
+	Redacted                 bool                  `json:"redacted" firestore:"redacted"`
+	Blacklisted              bool                  `json:"blacklisted" firestore:"blacklisted"`
+}
+
+// CrucibleMapIndexEntry points an activity at the key of the crucible map it is played on.
+type CrucibleMapIndexEntry struct {
+	ActivityHash int64  `json:"activityHash" firestore:"activityHash"`
+	Key          string `json:"key" firestore:"key"`
+}
+
 type ActivityDefinition struct {
 	ActivityLightLevel        int                       `json:"activityLightLevel" firestore:"activityLightLevel"`
 	ActivityLocationMappings  []any                     `json:"activityLocationMappings" firestore:"activityLocationMappings"`
@@ -300,6 +376,8 @@ type ActivityDefinition struct {
 	Rewards                   []any                     `json:"rewards" firestore:"rewards"`
 	SuppressOtherRewards      bool                      `json:"suppressOtherRewards" firestore:"suppressOtherRewards"`
 	Tier                      int                       `json:"tier" firestore:"tier"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 
 type ActivityDisplayProperties struct {
@@ -326,6 +404,8 @@ type PerkDefinition struct {
 	DamageTypeHash    int64                       `json:"damageTypeHash" firestore:"damageTypeHash"`
 	Redacted          bool                        `json:"redacted" firestore:"redacted"`
 	Blacklisted       bool                        `json:"blacklisted" firestore:"blacklisted"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 
 type DamageTypeDisplayProperties struct {
@@ -346,6 +426,8 @@ type StatDefinition struct {
 	Interpolate       bool                  `json:"interpolate" firestore:"interpolate"`
 	Redacted          bool                  `json:"redacted" firestore:"redacted"`
 	Blacklisted       bool                  `json:"blacklisted" firestore:"blacklisted"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 
 type StatDisplayProperties struct {
@@ -370,6 +452,8 @@ type DamageType struct {
 	Index               int                     `json:"index" firestore:"index"`
 	Redacted            bool                    `json:"redacted" firestore:"redacted"`
 	Blacklisted         bool                    `json:"blacklisted" firestore:"blacklisted"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 type DamageDisplayProperties struct {
 	Description string `json:"description" firestore:"description"`
@@ -507,6 +591,8 @@ type ActivityModeDefinition struct {
 	Index                 int                   `json:"index" firestore:"index"`
 	Redacted              bool                  `json:"redacted" firestore:"redacted"`
 	Blacklisted           bool                  `json:"blacklisted" firestore:"blacklisted"`
+	// Locales holds the display properties in every extra migrated locale, keyed by locale
+	Locales map[string]LocalizedDisplayProperties `json:"locales,omitempty" firestore:"locales,omitempty"`
 }
 
 type ManifestResponse struct {
@@ -577,6 +663,9 @@ const Energy = 2465295065
 const Power = 953998645
 const SubClass = 3284755031
 
+// WeaponPerksSocketCategory is the socket category holding a weapon's perk columns, barrel through trait and origin
+const WeaponPerksSocketCategory = 4241085061
+
 type ArmorBucket = uint32
 
 const (
@@ -615,6 +704,9 @@ const (
 	ItemDefinitionCollection   ManifestCollection = "d2ItemDefinitions"
 	SandboxPerkCollection      ManifestCollection = "d2SandboxPerks"
 	RecordDefinitionCollection ManifestCollection = "d2RecordDefinitions"
+	PlugSetCollection          ManifestCollection = "d2PlugSets"
+	SeasonCollection           ManifestCollection = "d2Seasons"
+	CrucibleMapIndexCollection ManifestCollection = "crucibleMapIndex"
 )
 
 type Session struct {
@@ -630,8 +722,14 @@ type Session struct {
 	StartedAt          time.Time      `firestore:"startedAt" json:"startedAt"`
 	StartedBy          *AuditField    `firestore:"startedBy" json:"startedBy,omitempty"`
 	Status             *SessionStatus `firestore:"status" json:"status,omitempty"`
-	UserID             string         `firestore:"userId" json:"userId"`
-	UpdatedAt          *time.Time     `firestore:"updatedAt" json:"updatedAt"`
+	// Summary Recap of the session's games, set when the session ends
+	Summary *SessionSummary `firestore:"summary,omitempty" json:"summary,omitempty"`
+	// EndReason Why the tick ended the session: stale or inactive
+	EndReason *SessionEndReason `firestore:"endReason,omitempty" json:"endReason,omitempty"`
+	// TrackedAt When a tick first picked the session up
+	TrackedAt *time.Time `firestore:"trackedAt,omitempty" json:"trackedAt,omitempty"`
+	UserID    string     `firestore:"userId" json:"userId"`
+	UpdatedAt *time.Time `firestore:"updatedAt" json:"updatedAt"`
 }
 
 const (
@@ -646,7 +744,7 @@ type AuditField struct {
 type SessionStatus string
 
 func GetActivities(ctx context.Context, db *firestore.Client) (map[string]ActivityDefinition, error) {
-	docs, err := db.Collection(string(ActivityCollection)).Documents(ctx).GetAll()
+	docs, err := db.Collection(ActivityCollection.Name()).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
@@ -663,7 +761,7 @@ func GetActivities(ctx context.Context, db *firestore.Client) (map[string]Activi
 func GetActivity(ctx context.Context, db *firestore.Client, hash int64) (*ActivityDefinition, error) {
 	hashStr := strconv.FormatInt(hash, 10)
 
-	doc, err := db.Collection(string(ActivityCollection)).Doc(hashStr).Get(ctx)
+	doc, err := db.Collection(ActivityCollection.Name()).Doc(hashStr).Get(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get activity definition: %w", err)
 	}
@@ -677,7 +775,7 @@ func GetActivity(ctx context.Context, db *firestore.Client, hash int64) (*Activi
 }
 
 func GetActivityModes(ctx context.Context, db *firestore.Client) (map[string]ActivityModeDefinition, error) {
-	docs, err := db.Collection(string(ActivityModeCollection)).Documents(ctx).GetAll()
+	docs, err := db.Collection(ActivityModeCollection.Name()).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
@@ -694,7 +792,7 @@ func GetActivityModes(ctx context.Context, db *firestore.Client) (map[string]Act
 func GetActivityMode(ctx context.Context, db *firestore.Client, hash int64) (*ActivityModeDefinition, error) {
 	hashStr := strconv.FormatInt(hash, 10)
 
-	doc, err := db.Collection(string(ActivityModeCollection)).Doc(hashStr).Get(ctx)
+	doc, err := db.Collection(ActivityModeCollection.Name()).Doc(hashStr).Get(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get activity mode definition: %w", err)
 	}
@@ -708,7 +806,7 @@ func GetActivityMode(ctx context.Context, db *firestore.Client, hash int64) (*Ac
 }
 
 func GetStats(ctx context.Context, db *firestore.Client) (map[string]StatDefinition, error) {
-	docs, err := db.Collection(string(StatDefinitionCollection)).Documents(ctx).GetAll()
+	docs, err := db.Collection(StatDefinitionCollection.Name()).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
@@ -722,7 +820,7 @@ func GetStats(ctx context.Context, db *firestore.Client) (map[string]StatDefinit
 }
 
 func GetItems(ctx context.Context, db *firestore.Client) (map[string]ItemDefinition, error) {
-	docs, err := db.Collection(string(ItemDefinitionCollection)).Documents(ctx).GetAll()
+	docs, err := db.Collection(ItemDefinitionCollection.Name()).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
@@ -739,7 +837,7 @@ func GetItems(ctx context.Context, db *firestore.Client) (map[string]ItemDefinit
 func GetItem(ctx context.Context, db *firestore.Client, hash int64) (*ItemDefinition, error) {
 	hashStr := strconv.FormatInt(hash, 10)
 
-	doc, err := db.Collection(string(ItemDefinitionCollection)).Doc(hashStr).Get(ctx)
+	doc, err := db.Collection(ItemDefinitionCollection.Name()).Doc(hashStr).Get(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get item definition: %w", err)
 	}
@@ -753,7 +851,7 @@ func GetItem(ctx context.Context, db *firestore.Client, hash int64) (*ItemDefini
 }
 
 func GetPerks(ctx context.Context, db *firestore.Client) (map[string]PerkDefinition, error) {
-	docs, err := db.Collection(string(SandboxPerkCollection)).Documents(ctx).GetAll()
+	docs, err := db.Collection(SandboxPerkCollection.Name()).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
@@ -769,7 +867,7 @@ func GetPerks(ctx context.Context, db *firestore.Client) (map[string]PerkDefinit
 func GetPerk(ctx context.Context, db *firestore.Client, hash int64) (*PerkDefinition, error) {
 	hashStr := strconv.FormatInt(hash, 10)
 
-	doc, err := db.Collection(string(SandboxPerkCollection)).Doc(hashStr).Get(ctx)
+	doc, err := db.Collection(SandboxPerkCollection.Name()).Doc(hashStr).Get(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get item definition: %w", err)
 	}
@@ -783,7 +881,7 @@ func GetPerk(ctx context.Context, db *firestore.Client, hash int64) (*PerkDefini
 }
 
 func GetDamageTypes(ctx context.Context, db *firestore.Client) (map[string]DamageType, error) {
-	docs, err := db.Collection(string(DamageCollection)).Documents(ctx).GetAll()
+	docs, err := db.Collection(DamageCollection.Name()).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
@@ -808,7 +906,7 @@ func batchedFetch[T any](ctx context.Context, db *firestore.Client, collection M
 		batch := idStrs[i:end]
 		refs := make([]*firestore.DocumentRef, 0, len(batch))
 		for _, id := range batch {
-			refs = append(refs, db.Collection(string(collection)).Doc(id))
+			refs = append(refs, db.Collection(collection.Name()).Doc(id))
 		}
 		docs, err := db.GetAll(ctx, refs)
 		if err != nil {
@@ -901,7 +999,7 @@ func GetDamageTypesByIDs(ctx context.Context, db *firestore.Client, ids []int64)
 	return utils.ToMap[DamageType, string](items, func(t DamageType) string { return strconv.FormatInt(t.Hash, 10) })
 }
 
-func buildLoadout(ctx context.Context, db *firestore.Client, client *bungie.ClientWithResponses, membershipID int64, membershipType int64, items []bungie.ItemComponent, stats map[string]StatDefinition) (Loadout, error) {
+func buildLoadout(ctx context.Context, db *firestore.Client, client *bungie.ClientWithResponses, membershipID int64, membershipType int64, items []bungie.ItemComponent, stats map[string]StatDefinition, locale string) (Loadout, error) {
 	loadout := make(Loadout)
 	destinyItems := make(map[string]bungie.DestinyItem)
 	destinyItemStylesMapping := make(map[string]string)
@@ -911,7 +1009,7 @@ func buildLoadout(ctx context.Context, db *firestore.Client, client *bungie.Clie
 		itemHashes []int64
 		perkHashes []int64
 	)
-	l := log.With().Int64("membershipId", membershipID).Logger()
+	l := zerolog.Ctx(ctx).With().Int64("membershipId", membershipID).Logger()
 	for _, item := range items {
 		if item.ItemInstanceId == nil {
 			l.Warn().Msgf("no instance id found")
@@ -977,6 +1075,58 @@ func buildLoadout(ctx context.Context, db *firestore.Client, client *bungie.Clie
 	if err != nil {
 		return nil, err
 	}
+
+	var (
+		plugSetHashes []int64
+		seasonHashes  []int64
+	)
+	for itemHash := range destinyItems {
+		def, ok := d2Items[itemHash]
+		if !ok {
+			continue
+		}
+		if def.SeasonHash != 0 {
+			seasonHashes = append(seasonHashes, def.SeasonHash)
+		}
+		for _, index := range weaponPerkSockets(def) {
+			if hash := perkPlugSetHash(def.Sockets.SocketEntries[index]); hash != 0 {
+				plugSetHashes = append(plugSetHashes, hash)
+			}
+		}
+	}
+	// Perk columns and seasons only add to the loadout, so a manifest missing them doesn't fail the snapshot
+	var plugSets map[string]PlugSetDefinition
+	if len(plugSetHashes) > 0 {
+		plugSets, err = GetPlugSetsByIDs(ctx, db, plugSetHashes)
+		if err != nil {
+			l.Warn().Err(err).Msg("failed to get plug sets, leaving out perk columns")
+		}
+	}
+	var seasons map[string]SeasonDefinition
+	if len(seasonHashes) > 0 {
+		seasons, err = GetSeasonsByIDs(ctx, db, seasonHashes)
+		if err != nil {
+			l.Warn().Err(err).Msg("failed to get seasons, leaving out season of origin")
+		}
+	}
+	// Perks that can roll but aren't equipped still need their item definitions for names and icons
+	plugHashes := make(map[int64]bool)
+	for _, plugSet := range plugSets {
+		for _, plug := range plugSet.ReusablePlugItems {
+			if _, ok := d2Items[strconv.FormatInt(plug.PlugItemHash, 10)]; !ok {
+				plugHashes[plug.PlugItemHash] = true
+			}
+		}
+	}
+	if len(plugHashes) > 0 {
+		plugItems, err := GetItemsByIDs(ctx, db, slices.Collect(maps.Keys(plugHashes)))
+		if err != nil {
+			return nil, err
+		}
+		for hash, item := range plugItems {
+			d2Items[hash] = item
+		}
+	}
 	l.Debug().TimeDiff("delay", time.Now(), startTime).Msg("Grabbed all the data needed for the loadout")
 
 	for instanceID, detail := range destinyItems {
@@ -991,7 +1141,7 @@ func buildLoadout(ctx context.Context, db *firestore.Client, client *bungie.Clie
 				styleItem = &s
 			}
 		}
-		result := TransformItemToDetails(&detail, d2Items, damageTypes, perks, stats, styleItem)
+		result := TransformItemToDetails(ctx, &detail, d2Items, damageTypes, perks, stats, plugSets, seasons, styleItem, locale)
 		snap.Name = result.BaseInfo.Name
 		snap.ItemHash = result.BaseInfo.ItemHash
 		snap.ItemProperties = *result
@@ -1016,12 +1166,10 @@ func GetItemDetails(ctx context.Context, client *bungie.ClientWithResponses, mem
 		&params,
 	)
 	if err != nil {
-		slog.With(
-			"error",
-			err.Error(),
-			"weapon instance id",
-			instanceID,
-		).Error("Failed to get item details")
+		zerolog.Ctx(ctx).Error().
+			Err(err).
+			Str("weaponInstanceId", instanceID).
+			Msg("Failed to get item details")
 		return nil, err
 	}
 	if response.JSON200.DestinyItem == nil {
@@ -1030,3 +1178,81 @@ func GetItemDetails(ctx context.Context, client *bungie.ClientWithResponses, mem
 
 	return response.JSON200.DestinyItem, nil
 }
+
+// definitionsByIDs fetches definitions keyed by their hash in batches of up to 30.
+func definitionsByIDs[T any](ctx context.Context, db *firestore.Client, collection ManifestCollection, ids []int64, hash func(T) int64) (map[string]T, error) {
+	idStrs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		idStrs = append(idStrs, strconv.FormatInt(id, 10))
+	}
+	items, err := batchedFetch[T](ctx, db, collection, idStrs)
+	if err != nil {
+		return nil, err
+	}
+	return utils.ToMap[T, string](items, func(t T) string { return strconv.FormatInt(hash(t), 10) })
+}
+
+// GetPlugSetsByIDs returns the plug sets for the given hashes, used to list every perk that can roll in a column.
+func GetPlugSetsByIDs(ctx context.Context, db *firestore.Client, ids []int64) (map[string]PlugSetDefinition, error) {
+	return definitionsByIDs(ctx, db, PlugSetCollection, ids, func(t PlugSetDefinition) int64 { return t.Hash })
+}
+
+// GetCurrentSeason returns the season running at the given time, or nil when the manifest has none.
+func GetCurrentSeason(ctx context.Context, db *firestore.Client, at time.Time) (*SeasonDefinition, error) {
+	docs, err := db.Collection(SeasonCollection.Name()).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	seasons, err := utils.GetAllToStructs[SeasonDefinition](docs)
+	if err != nil {
+		return nil, err
+	}
+	for _, season := range seasons {
+		start, err := time.Parse(time.RFC3339, season.StartDate)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339, season.EndDate)
+		if err != nil {
+			continue
+		}
+		if !at.Before(start) && at.Before(end) {
+			return &season, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetSeasonsByIDs returns the seasons for the given hashes.
+func GetSeasonsByIDs(ctx context.Context, db *firestore.Client, ids []int64) (map[string]SeasonDefinition, error) {
+	return definitionsByIDs(ctx, db, SeasonCollection, ids, func(t SeasonDefinition) int64 { return t.Hash })
+}
+
+// GetCrucibleMapKeysByIDs returns the crucible map of each of the given activity hashes. Unlike other definitions
+// most activities have no index entry, so missing documents are skipped instead of failing the lookup.
+func GetCrucibleMapKeysByIDs(ctx context.Context, db *firestore.Client, ids []int64) (map[string]CrucibleMapIndexEntry, error) {
+	const maxBatch = 30
+	result := make(map[string]CrucibleMapIndexEntry)
+	for i := 0; i < len(ids); i += maxBatch {
+		end := min(i+maxBatch, len(ids))
+		refs := make([]*firestore.DocumentRef, 0, end-i)
+		for _, id := range ids[i:end] {
+			refs = append(refs, db.Collection(CrucibleMapIndexCollection.Name()).Doc(strconv.FormatInt(id, 10)))
+		}
+		docs, err := db.GetAll(ctx, refs)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+			var entry CrucibleMapIndexEntry
+			if err := doc.DataTo(&entry); err != nil {
+				return nil, fmt.Errorf("failed to convert doc %s: %w", doc.Ref.ID, err)
+			}
+			result[doc.Ref.ID] = entry
+		}
+	}
+	return result, nil
+}
@@ -19,13 +19,7 @@ func GetMembershipType(ctx context.Context, db *firestore.Client, userID string)
 	if err != nil {
 		return 0, "", fmt.Errorf("failed to fetch user: %w", err)
 	}
-	membershipType := int64(0)
-	for _, membership := range u.Memberships {
-		if membership.ID == u.PrimaryMembershipID {
-			membershipType = membership.Type
-		}
-	}
-	return membershipType, u.PrimaryMembershipID, nil
+	return u.MembershipType(), u.PrimaryMembershipID, nil
 }
 func GetUser(ctx context.Context, db *firestore.Client, ID string) (*User, error) {
 	user := User{}
@@ -70,6 +64,26 @@ func GetUser(ctx context.Context, db *firestore.Client, ID string) (*User, error
 	return nil, fmt.Errorf("not found")
 }
 
+// GetUserByCharacter finds the user that owns the character.
+func GetUserByCharacter(ctx context.Context, db *firestore.Client, characterID string) (*User, error) {
+	docs, err := db.Collection(userCollection).
+		Where("characterIds", "array-contains", characterID).
+		Limit(1).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("not found")
+	}
+	user := User{}
+	if err := docs[0].DataTo(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 type User struct {
 	ID                  string       `json:"id" firestore:"id"`
 	MemberID            string       `json:"memberId" firestore:"memberId"`
@@ -79,6 +93,18 @@ type User struct {
 	Memberships         []Membership `json:"memberships" firestore:"memberships"`
 	CreatedAt           time.Time    `json:"createdAt" firestore:"createdAt"`
 	CharacterIDs        []string     `json:"characterIDs" firestore:"characterIds"`
+	// Locale is the Bungie locale names are shown in, e.g. "de". Empty means English
+	Locale string `json:"locale" firestore:"locale"`
+}
+
+// MembershipType returns the platform of the user's primary membership.
+func (u User) MembershipType() int64 {
+	for _, membership := range u.Memberships {
+		if membership.ID == u.PrimaryMembershipID {
+			return membership.Type
+		}
+	}
+	return 0
 }
 
 type Membership struct {
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+)
+
+const (
+	weaponStatsCollection = "weaponStats"
+	// weaponStatsWeapons is the subcollection of a user's weapon stats holding one document per weapon
+	weaponStatsWeapons = "weapons"
+)
+
+// WeaponStats is the summary of how the weapons a user used performed. Each weapon has its own document under
+// weapons, keyed by item hash, so a user with many weapons, rolls and perk combinations can't outgrow a document.
+type WeaponStats struct {
+	UserID     string    `firestore:"userId" json:"userId"`
+	ComputedAt time.Time `firestore:"computedAt" json:"computedAt"`
+	// Windows is keyed by window name: 7d, 30d or season
+	Windows map[string]WeaponStatsWindow `firestore:"windows" json:"windows"`
+}
+
+type WeaponStatsWindow struct {
+	From time.Time `firestore:"from" json:"from"`
+	// Weapons is how many weapons got kills in the window
+	Weapons int `firestore:"weapons" json:"weapons"`
+}
+
+// WeaponWindows is a single weapon's performance in every window it got kills in.
+type WeaponWindows struct {
+	UserID      string    `firestore:"userId" json:"userId"`
+	ReferenceID int64     `firestore:"referenceId" json:"referenceId"`
+	Name        string    `firestore:"name" json:"name"`
+	ComputedAt  time.Time `firestore:"computedAt" json:"computedAt"`
+	// Windows is keyed by window name, only for the windows the weapon was used in
+	Windows map[string]WeaponPerformance `firestore:"windows" json:"windows"`
+}
+
+// WeaponTotals are a weapon's numbers over the games it got at least one kill in.
+type WeaponTotals struct {
+	Games          int     `firestore:"games" json:"games"`
+	Wins           int     `firestore:"wins" json:"wins"`
+	Kills          int64   `firestore:"kills" json:"kills"`
+	PrecisionKills int64   `firestore:"precisionKills" json:"precisionKills"`
+	PrecisionRatio float64 `firestore:"precisionRatio" json:"precisionRatio"`
+	// WinRate of the games the weapon was used in
+	WinRate float64 `firestore:"winRate" json:"winRate"`
+}
+
+func (t *WeaponTotals) add(metrics WeaponInstanceMetrics, win bool) {
+	t.Games++
+	if win {
+		t.Wins++
+	}
+	t.Kills += int64(uniqueStatValue(metrics.Stats, "uniqueWeaponKills"))
+	t.PrecisionKills += int64(uniqueStatValue(metrics.Stats, "uniqueWeaponPrecisionKills"))
+}
+
+func (t *WeaponTotals) finish() {
+	if t.Games == 0 {
+		return
+	}
+	t.WinRate = float64(t.Wins) / float64(t.Games)
+	if t.Kills > 0 {
+		t.PrecisionRatio = float64(t.PrecisionKills) / float64(t.Kills)
+	}
+}
+
+type WeaponPerformance struct {
+	ReferenceID int64  `firestore:"referenceId" json:"referenceId"`
+	Name        string `firestore:"name" json:"name"`
+	WeaponTotals
+	// Modes breaks the totals down by activity mode name
+	Modes map[string]WeaponTotals `firestore:"modes" json:"modes"`
+	// Rolls breaks the totals down by item instance, only for games linked to a snapshot holding the weapon
+	Rolls map[string]WeaponTotals `firestore:"rolls" json:"rolls"`
+	// Perks breaks the totals down by the combination of perks on the weapon, keyed by the sorted perk hashes
+	Perks map[string]WeaponPerkTotals `firestore:"perks" json:"perks"`
+}
+
+type WeaponPerkTotals struct {
+	PerkHashes []int64  `firestore:"perkHashes" json:"perkHashes"`
+	PerkNames  []string `firestore:"perkNames" json:"perkNames"`
+	WeaponTotals
+}
+
+func (p *WeaponPerformance) add(history ActivityHistory, metrics WeaponInstanceMetrics, win bool) {
+	if metrics.Display != nil {
+		p.Name = metrics.Display.Name
+	}
+	p.WeaponTotals.add(metrics, win)
+
+	mode := "Unknown"
+	if history.Mode != nil {
+		mode = *history.Mode
+	}
+	modeTotals := p.Modes[mode]
+	modeTotals.add(metrics, win)
+	p.Modes[mode] = modeTotals
+
+	properties := metrics.ItemProperties
+	if properties == nil {
+		return
+	}
+	if properties.BaseInfo.InstanceId != "" {
+		roll := p.Rolls[properties.BaseInfo.InstanceId]
+		roll.add(metrics, win)
+		p.Rolls[properties.BaseInfo.InstanceId] = roll
+	}
+	if len(properties.Perks) > 0 {
+		key, combo := perkCombination(properties.Perks)
+		if existing, ok := p.Perks[key]; ok {
+			combo = existing
+		}
+		combo.add(metrics, win)
+		p.Perks[key] = combo
+	}
+}
+
+func (p *WeaponPerformance) finish() {
+	p.WeaponTotals.finish()
+	for mode, totals := range p.Modes {
+		totals.finish()
+		p.Modes[mode] = totals
+	}
+	for instanceID, totals := range p.Rolls {
+		totals.finish()
+		p.Rolls[instanceID] = totals
+	}
+	for key, totals := range p.Perks {
+		totals.finish()
+		p.Perks[key] = totals
+	}
+}
+
+// perkCombination keys a set of perks by their sorted hashes so the same roll always lands in the same bucket.
+func perkCombination(perks []Perk) (string, WeaponPerkTotals) {
+	sorted := slices.Clone(perks)
+	slices.SortFunc(sorted, func(a, b Perk) int { return cmp.Compare(a.Hash, b.Hash) })
+	combo := WeaponPerkTotals{
+		PerkHashes: make([]int64, 0, len(sorted)),
+		PerkNames:  make([]string, 0, len(sorted)),
+	}
+	keys := make([]string, 0, len(sorted))
+	for _, perk := range sorted {
+		combo.PerkHashes = append(combo.PerkHashes, perk.Hash)
+		combo.PerkNames = append(combo.PerkNames, perk.Name)
+		keys = append(keys, strconv.FormatInt(perk.Hash, 10))
+	}
+	return strings.Join(keys, "-"), combo
+}
+
+// ComputeWeaponStats totals every weapon the user got kills with for every rollup window. It returns the summary and
+// each weapon keyed by item hash.
+func ComputeWeaponStats(user User, source *rollupSource) (*WeaponStats, map[string]*WeaponWindows) {
+	windows := source.Windows
+	weapons := make(map[string]*WeaponWindows)
+	forEachGame(user, source, func(game rollupGame, in []RollupWindow) {
+		win := isWin(game.Performance.PlayerStats)
+		for _, metrics := range game.Performance.Weapons {
+			if metrics.ReferenceID == nil {
+				continue
+			}
+			ID := strconv.FormatInt(*metrics.ReferenceID, 10)
+			weapon, ok := weapons[ID]
+			if !ok {
+				weapon = &WeaponWindows{
+					UserID:      user.ID,
+					ReferenceID: *metrics.ReferenceID,
+					ComputedAt:  source.Now,
+					Windows:     make(map[string]WeaponPerformance),
+				}
+				weapons[ID] = weapon
+			}
+			for _, window := range in {
+				p, ok := weapon.Windows[window.Name]
+				if !ok {
+					p = WeaponPerformance{
+						ReferenceID: *metrics.ReferenceID,
+						Modes:       make(map[string]WeaponTotals),
+						Rolls:       make(map[string]WeaponTotals),
+						Perks:       make(map[string]WeaponPerkTotals),
+					}
+				}
+				p.add(game.Aggregate.ActivityDetails, metrics, win)
+				weapon.Windows[window.Name] = p
+			}
+			if metrics.Display != nil {
+				weapon.Name = metrics.Display.Name
+			}
+		}
+	})
+
+	stats := &WeaponStats{UserID: user.ID, ComputedAt: source.Now, Windows: make(map[string]WeaponStatsWindow, len(windows))}
+	for _, window := range windows {
+		stats.Windows[window.Name] = WeaponStatsWindow{From: window.From}
+	}
+	for _, weapon := range weapons {
+		for name, p := range weapon.Windows {
+			p.finish()
+			weapon.Windows[name] = p
+			summary := stats.Windows[name]
+			summary.Weapons++
+			stats.Windows[name] = summary
+		}
+	}
+	return stats, weapons
+}
+
+// RollupWeaponStats recomputes and stores the user's weapon stats. Weapons that no longer got kills in any window
+// are deleted, and the summary is written last so its computedAt is only updated once every weapon is.
+func RollupWeaponStats(ctx context.Context, db *firestore.Client, w Writer, user User, source *rollupSource) (*WeaponStats, error) {
+	stats, weapons := ComputeWeaponStats(user, source)
+	ref := db.Collection(weaponStatsCollection).Doc(user.ID)
+	existing, err := ref.Collection(weaponStatsWeapons).DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list weapon stats: %w", err)
+	}
+	for ID, weapon := range weapons {
+		if err := w.Set(ctx, ref.Collection(weaponStatsWeapons).Doc(ID), weapon); err != nil {
+			return nil, fmt.Errorf("failed to save weapon stats for %s: %w", ID, err)
+		}
+	}
+	for _, doc := range existing {
+		if _, ok := weapons[doc.ID]; ok {
+			continue
+		}
+		if err := w.Delete(ctx, doc); err != nil {
+			return nil, fmt.Errorf("failed to delete weapon stats for %s: %w", doc.ID, err)
+		}
+	}
+	if err := w.Set(ctx, ref, stats); err != nil {
+		return nil, fmt.Errorf("failed to save weapon stats: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("userId", user.ID).
+		Int("weapons", len(weapons)).
+		Msg("rolled up weapon stats")
+	return stats, nil
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"serverTick/utils"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	webhookCollection         = "webhooks"
+	webhookDeliveryCollection = "webhookDeliveries"
+
+	webhookMaxAttempts = 4
+	// webhookBaseBackoff is the wait before the first retry, doubling for every retry after it
+	webhookBaseBackoff = time.Minute
+	webhookMaxBackoff  = 30 * time.Minute
+	webhookTimeout     = 10 * time.Second
+	// webhookDeliveryDeadline bounds the whole delivery pass of a tick
+	webhookDeliveryDeadline = time.Minute
+	// webhookDeliveryBatch is the most deliveries attempted in one tick
+	webhookDeliveryBatch = 200
+	webhookConcurrency   = 8
+
+	webhookEventHeader     = "X-OneTrick-Event"
+	webhookDeliveryHeader  = "X-OneTrick-Delivery"
+	webhookSignatureHeader = "X-OneTrick-Signature"
+	webhookTimestampHeader = "X-OneTrick-Timestamp"
+)
+
+type WebhookEventType string
+
+const (
+	SessionStartedEvent  WebhookEventType = "session.started"
+	MatchRecordedEvent   WebhookEventType = "match.recorded"
+	SnapshotCreatedEvent WebhookEventType = "snapshot.created"
+	SessionEndedEvent    WebhookEventType = "session.ended"
+)
+
+type SessionEndReason string
+
+const (
+	// StaleSessionReason The session saw no new games for CutOffHours
+	StaleSessionReason SessionEndReason = "stale"
+	// InactiveSessionReason The session never saw a game in CutOffHours since it started
+	InactiveSessionReason SessionEndReason = "inactive"
+)
+
+type WebhookFormat string
+
+const (
+	// JSONWebhookFormat Posts the WebhookEvent as is
+	JSONWebhookFormat WebhookFormat = "json"
+	// DiscordWebhookFormat Posts the event as Discord embeds, for Discord channel webhook URLs
+	DiscordWebhookFormat WebhookFormat = "discord"
+)
+
+const (
+	// DeliveryPending The delivery is queued, or waiting for its next attempt
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint a user registered to hear about their sessions.
+type Webhook struct {
+	ID     string `firestore:"id" json:"id"`
+	UserID string `firestore:"userId" json:"userId"`
+	URL    string `firestore:"url" json:"url"`
+	// Secret signs every payload with HMAC-SHA256 so the endpoint can tell it came from us. Unsigned when empty
+	Secret string `firestore:"secret" json:"-"`
+	// Events the endpoint wants. Every event is sent when empty
+	Events []WebhookEventType `firestore:"events" json:"events"`
+	// Format of the body: json or discord. json when empty
+	Format    WebhookFormat `firestore:"format" json:"format"`
+	Enabled   bool          `firestore:"enabled" json:"enabled"`
+	CreatedAt time.Time     `firestore:"createdAt" json:"createdAt"`
+}
+
+func (h Webhook) wants(event WebhookEventType) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+// WebhookEvent is the payload posted to every endpoint.
+type WebhookEvent struct {
+	ID          string           `json:"id"`
+	Type        WebhookEventType `json:"type"`
+	UserID      string           `json:"userId"`
+	SessionID   string           `json:"sessionId"`
+	CharacterID string           `json:"characterId"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	Data        any              `json:"data"`
+}
+
+type SessionStartedData struct {
+	Name      *string   `json:"name,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// MatchRecordedData is the game the character just played with what's needed to show it off.
+type MatchRecordedData struct {
+	SessionGame
+	ImageURL string `json:"imageUrl"`
+	// Standing Victory or Defeat
+	Standing string `json:"standing"`
+	// Weapons used in the game, most kills first
+	Weapons      []SessionWeapon `json:"weapons"`
+	SnapshotName string          `json:"snapshotName,omitempty"`
+}
+
+type SnapshotCreatedData struct {
+	SnapshotID string `json:"snapshotId"`
+	Name       string `json:"name"`
+}
+
+type SessionEndedData struct {
+	Reason  SessionEndReason `json:"reason"`
+	Summary *SessionSummary  `json:"summary,omitempty"`
+}
+
+// NewMatchRecordedData builds the match event for the character's game in the aggregate. The snapshot name is
+// fetched for linked games and left out when that fails.
+func NewMatchRecordedData(ctx context.Context, db *firestore.Client, aggregate Aggregate, characterID string, performance InstancePerformance) MatchRecordedData {
+	data := MatchRecordedData{
+		SessionGame: NewSessionGame(aggregate, characterID, performance),
+		ImageURL:    aggregate.ActivityDetails.ImageURL,
+		Standing:    "Defeat",
+		Weapons:     make([]SessionWeapon, 0, len(performance.Weapons)),
+	}
+	if data.Win {
+		data.Standing = "Victory"
+	}
+	if standing := performance.PlayerStats.Standing; standing != nil && standing.DisplayValue != nil {
+		data.Standing = *standing.DisplayValue
+	}
+	for _, metrics := range performance.Weapons {
+		if metrics.ReferenceID == nil {
+			continue
+		}
+		weapon := SessionWeapon{
+			ReferenceID: *metrics.ReferenceID,
+			Kills:       int64(uniqueStatValue(metrics.Stats, "uniqueWeaponKills")),
+			Games:       1,
+		}
+		if metrics.Display != nil {
+			weapon.Name = metrics.Display.Name
+			weapon.Icon = metrics.Display.Icon
+		}
+		data.Weapons = append(data.Weapons, weapon)
+	}
+	slices.SortFunc(data.Weapons, func(a, b SessionWeapon) int {
+		if a.Kills != b.Kills {
+			return cmp.Compare(b.Kills, a.Kills)
+		}
+		return cmp.Compare(a.ReferenceID, b.ReferenceID)
+	})
+	if data.SnapshotID != nil {
+		if snapshot, err := Get(ctx, db, *data.SnapshotID); err == nil && snapshot != nil {
+			data.SnapshotName = snapshot.Name
+		} else {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("snapshotId", *data.SnapshotID).Msg("failed to get snapshot name for match event")
+		}
+	}
+	return data
+}
+
+// WebhookDelivery is the log of one event sent to one endpoint.
+type WebhookDelivery struct {
+	ID        string           `firestore:"id" json:"id"`
+	WebhookID string           `firestore:"webhookId" json:"webhookId"`
+	UserID    string           `firestore:"userId" json:"userId"`
+	EventID   string           `firestore:"eventId" json:"eventId"`
+	Event     WebhookEventType `firestore:"event" json:"event"`
+	SessionID string           `firestore:"sessionId" json:"sessionId"`
+	// Body The encoded payload, kept so every attempt sends the same bytes
+	Body     string           `firestore:"body" json:"body"`
+	Status   string           `firestore:"status" json:"status"`
+	Attempts []WebhookAttempt `firestore:"attempts" json:"attempts"`
+	// NextAttemptAt When the delivery is due, only meaningful while pending
+	NextAttemptAt time.Time  `firestore:"nextAttemptAt" json:"nextAttemptAt"`
+	CreatedAt     time.Time  `firestore:"createdAt" json:"createdAt"`
+	CompletedAt   *time.Time `firestore:"completedAt" json:"completedAt,omitempty"`
+}
+
+type WebhookAttempt struct {
+	At         time.Time `firestore:"at" json:"at"`
+	StatusCode int       `firestore:"statusCode" json:"statusCode"`
+	Error      string    `firestore:"error" json:"error,omitempty"`
+	// Duration Milliseconds the request took
+	Duration int64 `firestore:"duration" json:"duration"`
+}
+
+// Webhooks queues session events for the endpoints users registered and delivers them. During a dry run the queued
+// deliveries land in the change plan and nothing is sent.
+type Webhooks struct {
+	client *http.Client
+	dryRun bool
+}
+
+func NewWebhooks(dryRun bool) *Webhooks {
+	return &Webhooks{client: &http.Client{Timeout: webhookTimeout}, dryRun: dryRun}
+}
+
+// GetUserWebhooks fetches the user's enabled endpoints.
+func GetUserWebhooks(ctx context.Context, db *firestore.Client, userID string) ([]Webhook, error) {
+	docs, err := db.Collection(webhookCollection).
+		Where("userId", "==", userID).
+		Where("enabled", "==", true).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]Webhook, 0, len(docs))
+	for _, doc := range docs {
+		hook := Webhook{}
+		if err := doc.DataTo(&hook); err != nil {
+			return nil, fmt.Errorf("failed to convert webhook %s: %w", doc.Ref.ID, err)
+		}
+		hook.ID = doc.Ref.ID
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// Send queues the event for every endpoint of the session's user that wants it. Nothing is posted here so a slow
+// endpoint can't hold up the tick, DeliverPending sends the queue once every session has been processed.
+func (h *Webhooks) Send(ctx context.Context, db *firestore.Client, w Writer, session Session, eventType WebhookEventType, data any) {
+	if h == nil {
+		return
+	}
+	l := zerolog.Ctx(ctx).With().Str("event", string(eventType)).Logger()
+	hooks, err := GetUserWebhooks(ctx, db, session.UserID)
+	if err != nil {
+		l.Error().Err(err).Msg("failed to fetch webhooks")
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	event := WebhookEvent{
+		ID:          db.Collection(webhookDeliveryCollection).NewDoc().ID,
+		Type:        eventType,
+		UserID:      session.UserID,
+		SessionID:   session.ID,
+		CharacterID: session.CharacterID,
+		CreatedAt:   now,
+		Data:        data,
+	}
+	for _, hook := range hooks {
+		if !hook.wants(eventType) {
+			continue
+		}
+		body, err := encodeWebhookEvent(event, hook.Format)
+		if err != nil {
+			l.Error().Err(err).Str("webhookId", hook.ID).Msg("failed to encode webhook event")
+			continue
+		}
+		ref := db.Collection(webhookDeliveryCollection).NewDoc()
+		delivery := WebhookDelivery{
+			ID:            ref.ID,
+			WebhookID:     hook.ID,
+			UserID:        session.UserID,
+			EventID:       event.ID,
+			Event:         eventType,
+			SessionID:     session.ID,
+			Body:          string(body),
+			Status:        DeliveryPending,
+			Attempts:      make([]WebhookAttempt, 0),
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		if err := w.Set(ctx, ref, delivery); err != nil {
+			l.Error().Err(err).Str("webhookId", hook.ID).Msg("failed to queue webhook delivery")
+			continue
+		}
+		l.Debug().Str("webhookId", hook.ID).Str("deliveryId", delivery.ID).Msg("queued webhook delivery")
+	}
+}
+
+func encodeWebhookEvent(event WebhookEvent, format WebhookFormat) ([]byte, error) {
+	switch format {
+	case JSONWebhookFormat, "":
+		return json.Marshal(event)
+	case DiscordWebhookFormat:
+		return json.Marshal(DiscordMessageFor(event))
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q", format)
+	}
+}
+
+// GetDueDeliveries fetches the pending deliveries whose next attempt is due, oldest first.
+func GetDueDeliveries(ctx context.Context, db *firestore.Client, now time.Time) ([]WebhookDelivery, error) {
+	docs, err := db.Collection(webhookDeliveryCollection).
+		Where("status", "==", DeliveryPending).
+		Where("nextAttemptAt", "<=", now).
+		OrderBy("nextAttemptAt", firestore.Asc).
+		Limit(webhookDeliveryBatch).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return utils.GetAllToStructs[WebhookDelivery](docs)
+}
+
+// DeliverPending makes one attempt at every due delivery, a few at a time, within webhookDeliveryDeadline. Anything
+// not sent in time stays pending for the next tick. During a dry run the due deliveries are only logged.
+func (h *Webhooks) DeliverPending(ctx context.Context, db *firestore.Client, w Writer) {
+	if h == nil {
+		return
+	}
+	l := zerolog.Ctx(ctx)
+	deliveries, err := GetDueDeliveries(ctx, db, time.Now())
+	if err != nil {
+		l.Error().Err(err).Msg("failed to fetch pending webhook deliveries")
+		return
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if h.dryRun {
+		for _, delivery := range deliveries {
+			l.Info().Str("deliveryId", delivery.ID).Str("event", string(delivery.Event)).Msg("dry run, not sending webhook delivery")
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookDeliveryDeadline)
+	defer cancel()
+	webhooks := make(map[string]*Webhook)
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, webhookConcurrency)
+	)
+	for _, delivery := range deliveries {
+		hook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			hook, err = GetWebhook(ctx, db, delivery.WebhookID)
+			// A deleted endpoint fails its deliveries, any other error leaves them pending
+			if err != nil && status.Code(err) != codes.NotFound {
+				l.Warn().Err(err).Str("webhookId", delivery.WebhookID).Msg("failed to fetch webhook for delivery")
+				continue
+			}
+			webhooks[delivery.WebhookID] = hook
+		}
+		select {
+		case <-ctx.Done():
+			l.Warn().Msg("webhook delivery deadline reached, leaving the rest for the next tick")
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(delivery WebhookDelivery, hook *Webhook) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			h.attempt(ctx, db, w, hook, delivery)
+		}(delivery, hook)
+	}
+	wg.Wait()
+}
+
+// GetWebhook fetches a single endpoint by ID.
+func GetWebhook(ctx context.Context, db *firestore.Client, ID string) (*Webhook, error) {
+	doc, err := db.Collection(webhookCollection).Doc(ID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hook := Webhook{}
+	if err := doc.DataTo(&hook); err != nil {
+		return nil, err
+	}
+	hook.ID = doc.Ref.ID
+	return &hook, nil
+}
+
+// attempt posts the delivery once and records the result. Failures that are worth retrying are pushed back with an
+// exponential backoff, honoring Retry-After. Client errors other than rate limits aren't retried since sending the
+// same payload again won't change the answer.
+func (h *Webhooks) attempt(ctx context.Context, db *firestore.Client, w Writer, hook *Webhook, delivery WebhookDelivery) {
+	l := zerolog.Ctx(ctx).With().Str("deliveryId", delivery.ID).Str("webhookId", delivery.WebhookID).Logger()
+	now := time.Now()
+	if hook == nil || !hook.Enabled {
+		delivery.Status = DeliveryFailed
+		delivery.Attempts = append(delivery.Attempts, WebhookAttempt{At: now, Error: "webhook missing or disabled"})
+	} else {
+		result, retryAfter := h.post(ctx, *hook, delivery)
+		delivery.Attempts = append(delivery.Attempts, result)
+		switch {
+		case result.Error == "":
+			delivery.Status = DeliveryDelivered
+		case !retryable(result.StatusCode) || len(delivery.Attempts) >= webhookMaxAttempts:
+			delivery.Status = DeliveryFailed
+		default:
+			backoff := webhookBaseBackoff << (len(delivery.Attempts) - 1)
+			delivery.NextAttemptAt = now.Add(min(max(backoff, retryAfter), webhookMaxBackoff))
+		}
+	}
+	if delivery.Status != DeliveryPending {
+		delivery.CompletedAt = &now
+	}
+	err := w.Update(ctx, db.Collection(webhookDeliveryCollection).Doc(delivery.ID), []firestore.Update{
+		{Path: "status", Value: delivery.Status},
+		{Path: "attempts", Value: delivery.Attempts},
+		{Path: "nextAttemptAt", Value: delivery.NextAttemptAt},
+		{Path: "completedAt", Value: delivery.CompletedAt},
+	})
+	if err != nil {
+		l.Error().Err(err).Msg("failed to save webhook delivery")
+		return
+	}
+	l.Info().
+		Str("status", delivery.Status).
+		Int("attempts", len(delivery.Attempts)).
+		Msg("webhook delivery attempted")
+}
+
+func (h *Webhooks) post(ctx context.Context, hook Webhook, delivery WebhookDelivery) (WebhookAttempt, time.Duration) {
+	start := time.Now()
+	result := WebhookAttempt{At: start}
+	body := []byte(delivery.Body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result, 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "oneTrick-backend")
+	req.Header.Set(webhookEventHeader, string(delivery.Event))
+	req.Header.Set(webhookDeliveryHeader, delivery.ID)
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	if hook.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(hook.Secret, timestamp, body))
+	}
+
+	resp, err := h.client.Do(req)
+	result.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result, 0
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Error = resp.Status
+	}
+	retryAfter := time.Duration(0)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return result, retryAfter
+}
+
+// retryable reports whether an attempt that ended with the status code is worth trying again. A zero status code
+// means the request never got a response.
+func retryable(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// signWebhook is the hex encoded HMAC-SHA256 of the timestamp, a dot and the body, keyed by the endpoint's secret.
+// Signing the timestamp lets receivers reject old requests, so a captured one can't be replayed later.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"type":"match.recorded"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		want      string
+	}{
+		{
+			name:      "signs the timestamp and body",
+			secret:    "secret",
+			timestamp: "1700000000",
+			body:      body,
+			want:      "93e663b074949dc616ba5825db7cb143680f586fd1a219bf0dc75688d8c47e64",
+		},
+		{
+			name:      "empty body",
+			secret:    "secret",
+			timestamp: "1700000000",
+			body:      nil,
+			want:      "4bc5f74d868b97888288889c5d9d65df02526f94c1592a79fdf4fe8b26e311e5",
+		},
+		{
+			name:      "another secret",
+			secret:    "another-secret",
+			timestamp: "1700000000",
+			body:      body,
+			want:      "f078b10228de59fd7c3ec44e84c7e17abe7d6a44d8a9e0a59e0710ca9e167084",
+		},
+		{
+			name:      "a replay with a new timestamp doesn't match",
+			secret:    "secret",
+			timestamp: "1700000001",
+			body:      body,
+			want:      "b1bd5732ff6c489e9bc8171e84a1c79e6e8c9e150b06c2f3bdb91905304ab5c0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhook(tt.secret, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("signWebhook() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"serverTick/utils"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/rs/zerolog"
+)
+
+// Writer is the single place Firestore mutations go through. Reads still use the firestore client directly.
+type Writer interface {
+	// Set overwrites the document at ref, or merges into it when firestore.MergeAll is passed.
+	Set(ctx context.Context, ref *firestore.DocumentRef, data any, opts ...firestore.SetOption) error
+	// Update applies field level updates to an existing document.
+	Update(ctx context.Context, ref *firestore.DocumentRef, updates []firestore.Update) error
+	// Delete removes the document at ref.
+	Delete(ctx context.Context, ref *firestore.DocumentRef) error
+}
+
+// Transform is a server side field transform that can be recorded in a ChangePlan.
+// Use it instead of firestore.ArrayUnion/Increment so dry runs can show what would have been applied.
+type Transform struct {
+	Op     string `json:"op"`
+	Values []any  `json:"values"`
+}
+
+const (
+	arrayUnionOp = "arrayUnion"
+	incrementOp  = "increment"
+)
+
+// ArrayUnion adds values to an array field if they are not already present.
+func ArrayUnion(values ...any) Transform {
+	return Transform{Op: arrayUnionOp, Values: values}
+}
+
+// Increment adds n to a numeric field, creating it if it does not exist.
+func Increment(n any) Transform {
+	return Transform{Op: incrementOp, Values: []any{n}}
+}
+
+// FirestoreWriter applies every mutation straight to Firestore.
+type FirestoreWriter struct{}
+
+func (FirestoreWriter) Set(ctx context.Context, ref *firestore.DocumentRef, data any, opts ...firestore.SetOption) error {
+	_, err := ref.Set(ctx, toFirestoreValue(data), opts...)
+	return err
+}
+
+func (FirestoreWriter) Update(ctx context.Context, ref *firestore.DocumentRef, updates []firestore.Update) error {
+	converted := make([]firestore.Update, 0, len(updates))
+	for _, u := range updates {
+		u.Value = toFirestoreValue(u.Value)
+		converted = append(converted, u)
+	}
+	_, err := ref.Update(ctx, converted)
+	return err
+}
+
+func (FirestoreWriter) Delete(ctx context.Context, ref *firestore.DocumentRef) error {
+	_, err := ref.Delete(ctx)
+	return err
+}
+
+// toFirestoreValue swaps our Transform values for the firestore sentinels, walking nested maps.
+func toFirestoreValue(value any) any {
+	switch v := value.(type) {
+	case Transform:
+		switch v.Op {
+		case arrayUnionOp:
+			return firestore.ArrayUnion(v.Values...)
+		case incrementOp:
+			return firestore.Increment(v.Values[0])
+		}
+		return v
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, inner := range v {
+			result[key] = toFirestoreValue(inner)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+type Operation string
+
+const (
+	SetOperation    Operation = "set"
+	MergeOperation  Operation = "merge"
+	UpdateOperation Operation = "update"
+	DeleteOperation Operation = "delete"
+)
+
+// Change is a single mutation that would have been applied to Firestore.
+type Change struct {
+	Operation Operation `json:"operation"`
+	Path      string    `json:"path"`
+	Data      any       `json:"data,omitempty"`
+}
+
+// ChangePlan is every mutation captured during a dry run, in the order they were requested.
+type ChangePlan struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	Changes     []Change  `json:"changes"`
+}
+
+// DryRunWriter records mutations into a ChangePlan instead of applying them.
+type DryRunWriter struct {
+	mu   sync.Mutex
+	plan ChangePlan
+}
+
+func NewDryRunWriter() *DryRunWriter {
+	return &DryRunWriter{plan: ChangePlan{Changes: make([]Change, 0)}}
+}
+
+func (w *DryRunWriter) Set(ctx context.Context, ref *firestore.DocumentRef, data any, opts ...firestore.SetOption) error {
+	op := SetOperation
+	if len(opts) > 0 {
+		op = MergeOperation
+	}
+	w.record(ctx, Change{Operation: op, Path: ref.Path, Data: data})
+	return nil
+}
+
+func (w *DryRunWriter) Update(ctx context.Context, ref *firestore.DocumentRef, updates []firestore.Update) error {
+	fields := make(map[string]any, len(updates))
+	for _, u := range updates {
+		path := u.Path
+		if path == "" {
+			path = fmt.Sprint(u.FieldPath)
+		}
+		fields[path] = u.Value
+	}
+	w.record(ctx, Change{Operation: UpdateOperation, Path: ref.Path, Data: fields})
+	return nil
+}
+
+func (w *DryRunWriter) Delete(ctx context.Context, ref *firestore.DocumentRef) error {
+	w.record(ctx, Change{Operation: DeleteOperation, Path: ref.Path})
+	return nil
+}
+
+func (w *DryRunWriter) record(ctx context.Context, change Change) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.plan.Changes = append(w.plan.Changes, change)
+	zerolog.Ctx(ctx).Debug().
+		Str("operation", string(change.Operation)).
+		Str("path", change.Path).
+		Msg("recorded dry run change")
+}
+
+// Plan returns a copy of the changes recorded so far.
+func (w *DryRunWriter) Plan() ChangePlan {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return ChangePlan{
+		GeneratedAt: time.Now(),
+		Changes:     append([]Change(nil), w.plan.Changes...),
+	}
+}
+
+// WritePlan prints the plan to stdout, or writes it as JSON to path when one is provided.
+func (w *DryRunWriter) WritePlan(path string) error {
+	plan := w.Plan()
+	if path == "" {
+		utils.PrettyPrint(plan)
+		return nil
+	}
+	data, err := json.MarshalIndent(plan, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode change plan: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write change plan: %w", err)
+	}
+	return nil
+}